@@ -0,0 +1,67 @@
+package main
+
+import (
+	"github.com/rs/zerolog/log"
+	"github.com/steadybit/extension-auto-registration-kubernetes/autoregistration"
+	"github.com/steadybit/extension-auto-registration-kubernetes/config"
+)
+
+// createAgents creates the agents the extensions are registered at. Without a list of agents, the single agent of the
+// agent settings is used.
+func createAgents() []autoregistration.Agent {
+	if len(config.Config.Agents) == 0 {
+		return []autoregistration.Agent{{
+			Name: "default",
+			Client: createAgentClient("default", autoregistration.AgentClientOptions{
+				Url:         config.Config.AgentBaseUrl(),
+				UnixSocket:  config.Config.AgentUnixSocket,
+				Key:         config.Config.AgentKey,
+				KeyFile:     config.Config.AgentKeyFile,
+				TlsCaFile:   config.Config.AgentTlsCaFile,
+				TlsCertFile: config.Config.AgentTlsCertFile,
+				TlsKeyFile:  config.Config.AgentTlsKeyFile,
+			}),
+			StateFile: config.Config.StateFile,
+		}}
+	}
+
+	agents := make([]autoregistration.Agent, 0, len(config.Config.Agents))
+	for _, agent := range config.Config.Agents {
+		matchLabels, err := agent.MatchLabels.Selector()
+		if err != nil {
+			log.Fatal().Err(err).Str("agent", agent.Name).Msg("Invalid label selector of the agent.")
+		}
+		stateFile := agent.StateFile
+		if stateFile == "" && config.Config.StateFile != "" {
+			stateFile = config.Config.StateFile + "." + agent.Name
+		}
+		agents = append(agents, autoregistration.Agent{
+			Name: agent.Name,
+			Client: createAgentClient(agent.Name, autoregistration.AgentClientOptions{
+				Url:         agent.BaseUrl(),
+				UnixSocket:  agent.UnixSocket,
+				Key:         agent.Key,
+				KeyFile:     agent.KeyFile,
+				TlsCaFile:   agent.TlsCaFile,
+				TlsCertFile: agent.TlsCertFile,
+				TlsKeyFile:  agent.TlsKeyFile,
+			}),
+			Namespaces:  agent.Namespaces,
+			MatchLabels: matchLabels,
+			StateFile:   stateFile,
+		})
+	}
+	return agents
+}
+
+func createAgentClient(name string, options autoregistration.AgentClientOptions) autoregistration.AgentClient {
+	options.Name = name
+	options.Timeout = config.Config.AgentRequestTimeout
+	options.Retries = config.Config.AgentRequestRetries
+	agent, err := autoregistration.NewAgentClient(options)
+	if err != nil {
+		log.Fatal().Err(err).Str("agent", name).Msg("Failed to create the agent client.")
+	}
+	log.Info().Str("agent", name).Str("url", options.Url).Msg("Registering extensions at agent.")
+	return agent
+}
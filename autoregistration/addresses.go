@@ -0,0 +1,85 @@
+package autoregistration
+
+import (
+	"net"
+	"strconv"
+
+	"github.com/steadybit/extension-auto-registration-kubernetes/config"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// podIPs returns all IPs of the pod, starting with the primary one.
+func podIPs(pod *corev1.Pod) []string {
+	result := make([]string, 0, len(pod.Status.PodIPs)+1)
+	if pod.Status.PodIP != "" {
+		result = append(result, pod.Status.PodIP)
+	}
+	for _, podIP := range pod.Status.PodIPs {
+		if podIP.IP != "" && podIP.IP != pod.Status.PodIP {
+			result = append(result, podIP.IP)
+		}
+	}
+	return result
+}
+
+// hostIPs returns all IPs of the node the pod is running on, starting with the primary one.
+func hostIPs(pod *corev1.Pod) []string {
+	result := make([]string, 0, len(pod.Status.HostIPs)+1)
+	if pod.Status.HostIP != "" {
+		result = append(result, pod.Status.HostIP)
+	}
+	for _, hostIP := range pod.Status.HostIPs {
+		if hostIP.IP != "" && hostIP.IP != pod.Status.HostIP {
+			result = append(result, hostIP.IP)
+		}
+	}
+	return result
+}
+
+// usesHostNetworking reports whether the pod is reachable via the node, i.e. it runs in the host network or exposes a
+// host port.
+func usesHostNetworking(pod *corev1.Pod) bool {
+	if pod.Spec.HostNetwork {
+		return true
+	}
+	for _, container := range runningContainers(pod) {
+		for _, port := range container.Ports {
+			if port.HostPort > 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// preferredIP returns the first IP of the preferred family (see config.IpFamilyPrimary, config.IpFamilyIPv4 and
+// config.IpFamilyIPv6). It falls back to the first IP if no IP of the family exists.
+func preferredIP(ips []string, family string) string {
+	if len(ips) == 0 {
+		return ""
+	}
+	if family == config.IpFamilyIPv4 || family == config.IpFamilyIPv6 {
+		wantIPv6 := family == config.IpFamilyIPv6
+		for _, ip := range ips {
+			if isIPv6(ip) == wantIPv6 {
+				return ip
+			}
+		}
+	}
+	return ips[0]
+}
+
+func isIPv6(ip string) bool {
+	parsed := net.ParseIP(ip)
+	return parsed != nil && parsed.To4() == nil
+}
+
+// extensionUrl builds the url of an extension. IPv6 addresses are enclosed in brackets, the port is omitted if 0.
+func extensionUrl(protocol string, host string, port int, path string) string {
+	if port > 0 {
+		host = net.JoinHostPort(host, strconv.Itoa(port))
+	} else if isIPv6(host) {
+		host = "[" + host + "]"
+	}
+	return protocol + "://" + host + path
+}
@@ -0,0 +1,48 @@
+package autoregistration
+
+import (
+	"testing"
+
+	"github.com/steadybit/extension-auto-registration-kubernetes/config"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestPodIPs(t *testing.T) {
+	pod := &corev1.Pod{Status: corev1.PodStatus{
+		PodIP:  "10.0.0.1",
+		PodIPs: []corev1.PodIP{{IP: "10.0.0.1"}, {IP: "fd00::1"}},
+	}}
+	assert.Equal(t, []string{"10.0.0.1", "fd00::1"}, podIPs(pod))
+	assert.Empty(t, podIPs(&corev1.Pod{}))
+}
+
+func TestPreferredIP(t *testing.T) {
+	dualStack := []string{"10.0.0.1", "fd00::1"}
+	assert.Equal(t, "10.0.0.1", preferredIP(dualStack, config.IpFamilyPrimary))
+	assert.Equal(t, "10.0.0.1", preferredIP(dualStack, config.IpFamilyIPv4))
+	assert.Equal(t, "fd00::1", preferredIP(dualStack, config.IpFamilyIPv6))
+	assert.Equal(t, "10.0.0.1", preferredIP([]string{"10.0.0.1"}, config.IpFamilyIPv6), "should fall back to the primary ip")
+	assert.Empty(t, preferredIP([]string{}, config.IpFamilyIPv4))
+}
+
+func TestExtensionUrl(t *testing.T) {
+	assert.Equal(t, "http://10.0.0.1:8080/", extensionUrl("http", "10.0.0.1", 8080, "/"))
+	assert.Equal(t, "https://[fd00::1]:8080", extensionUrl("https", "fd00::1", 8080, ""))
+	assert.Equal(t, "http://[fd00::1]/ext", extensionUrl("http", "fd00::1", 0, "/ext"))
+	assert.Equal(t, "http://svc.ns.svc.cluster.local:8085", extensionUrl("http", "svc.ns.svc.cluster.local", 8085, ""))
+}
+
+func TestHostIPs(t *testing.T) {
+	pod := &corev1.Pod{Status: corev1.PodStatus{
+		HostIP:  "172.16.0.1",
+		HostIPs: []corev1.HostIP{{IP: "172.16.0.1"}, {IP: "fd00:1::1"}},
+	}}
+	assert.Equal(t, []string{"172.16.0.1", "fd00:1::1"}, hostIPs(pod))
+}
+
+func TestUsesHostNetworking(t *testing.T) {
+	assert.False(t, usesHostNetworking(&corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{Ports: []corev1.ContainerPort{{ContainerPort: 8080}}}}}}))
+	assert.True(t, usesHostNetworking(&corev1.Pod{Spec: corev1.PodSpec{HostNetwork: true}}))
+	assert.True(t, usesHostNetworking(&corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{Ports: []corev1.ContainerPort{{ContainerPort: 8080, HostPort: 18080}}}}}}))
+}
@@ -1,107 +1,162 @@
 package autoregistration
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"slices"
+	"time"
 
-	"github.com/go-resty/resty/v2"
 	"github.com/rs/zerolog/log"
-	extensionconfig "github.com/steadybit/extension-auto-registration-kubernetes/config"
+	"github.com/steadybit/extension-auto-registration-kubernetes/metrics"
+	corev1 "k8s.io/api/core/v1"
 )
 
-func getCurrentRegistrations(httpClient *resty.Client) ([]ExtensionConfigAO, error) {
-	var currentRegistrations *[]ExtensionConfigAO
-	resp, err := httpClient.R().
-		SetHeader("Accept", "application/json").
-		SetResult(&currentRegistrations).
-		Get("/extensions")
-
-	if err != nil {
-		log.Error().Err(err).Msg("Failed to get extension registrations from the agent. Skip.")
-		return nil, err
-	}
-	if resp.IsError() {
-		log.Error().Msgf("Failed to get extension registrations from the agent: %s. Skip.", resp.Status())
-		return nil, fmt.Errorf("failed to get extension registrations from the agent: %s", resp.Status())
+// waitForAgent polls the agent until it answers without a server error or the timeout is reached. The interval
+// between two attempts is doubled after each failed attempt, up to maxInterval.
+func waitForAgent(ctx context.Context, agent AgentClient, timeout time.Duration, interval time.Duration, maxInterval time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for attempt := 1; ; attempt++ {
+		_, err := agent.List(ctx)
+		var agentError *AgentError
+		if err == nil || (errors.As(err, &agentError) && agentError.StatusCode > 0 && agentError.StatusCode < 500) {
+			log.Info().Int("attempts", attempt).Msg("Agent is ready.")
+			return nil
+		}
+		if ctx.Err() != nil {
+			return errors.New("stopped while waiting for the agent")
+		}
+		if time.Now().Add(interval).After(deadline) {
+			return fmt.Errorf("agent not ready after %s: %w", timeout, err)
+		}
+		log.Debug().Err(err).Int("attempt", attempt).Dur("retryIn", interval).Msg("Agent not ready yet.")
+		select {
+		case <-ctx.Done():
+		case <-time.After(interval):
+		}
+		interval = min(interval*2, maxInterval)
 	}
-	if resp.IsSuccess() {
-		if currentRegistrations != nil {
-			log.Trace().Int("count", len(*currentRegistrations)).Msg("Got extension registrations from the agent")
-			return *currentRegistrations, nil
-		} else {
-			log.Trace().Msg("No extension registrations found on the agent")
+}
+
+// missingRegistrations returns the extensions which are not contained in the given registrations.
+func missingRegistrations(registrations []ExtensionConfigAO, extensions []ExtensionConfigAO) []ExtensionConfigAO {
+	result := make([]ExtensionConfigAO, 0)
+	for _, extension := range extensions {
+		if !slices.ContainsFunc(registrations, func(registration ExtensionConfigAO) bool {
+			return extensionsEqual(registration, extension)
+		}) {
+			result = append(result, extension)
 		}
 	}
-	return []ExtensionConfigAO{}, nil
+	return result
 }
 
-func removeMissingRegistrations(httpClient *resty.Client, currentRegistrations []ExtensionConfigAO, discoveredExtensions []ExtensionConfigAO) error {
+// outdatedRegistrations returns the registrations with the url of a discovered extension but changed restrictions.
+func outdatedRegistrations(currentRegistrations []ExtensionConfigAO, discoveredExtensions []ExtensionConfigAO) []ExtensionConfigAO {
+	return slices.DeleteFunc(missingRegistrations(discoveredExtensions, currentRegistrations), func(registration ExtensionConfigAO) bool {
+		return !slices.ContainsFunc(discoveredExtensions, func(e ExtensionConfigAO) bool { return e.Url == registration.Url })
+	})
+}
+
+// removeMissingRegistrations deregisters the owned registrations which are not discovered anymore, including outdated
+// registrations of a still discovered url. Registrations not created by the auto registration or protected by
+// configuration are never removed. Failed deregistrations are retried with backoff, only the failures of this attempt
+// are returned together with the deregistered registrations.
+func removeMissingRegistrations(ctx context.Context, agentName string, agent AgentClient, owned *ownership, failures *registrationFailures, currentRegistrations []ExtensionConfigAO, discoveredExtensions []ExtensionConfigAO, recordEvent eventRecorder) ([]ExtensionConfigAO, error) {
 	var combinedError error
+	removed := make([]ExtensionConfigAO, 0)
 
 	for _, currentRegistration := range currentRegistrations {
-		found := false
-		for _, discoveredExtension := range discoveredExtensions {
-			if extensionsEqual(currentRegistration, discoveredExtension) {
-				found = true
-				break
-			}
-		}
-		if !found {
-			resp, err := httpClient.R().
-				SetHeader("Content-Type", "application/json").
-				SetBasicAuth("_", extensionconfig.Config.AgentKey).
-				SetBody(currentRegistration).
-				Delete("/extensions")
-			if err != nil {
-				log.Error().Err(err).Msgf("Failed to deregister extension: %v", currentRegistration)
+		found := slices.ContainsFunc(discoveredExtensions, func(discoveredExtension ExtensionConfigAO) bool {
+			return extensionsEqual(currentRegistration, discoveredExtension)
+		})
+		if !found && !owned.isRemovable(currentRegistration) {
+			log.Trace().Str("url", currentRegistration.Url).Bool("owned", owned.isOwned(currentRegistration)).Msg("Keeping registration not owned by the auto registration or protected.")
+		} else if !found && !failures.shouldAttempt(operationDeregister, currentRegistration) {
+			log.Trace().Str("url", currentRegistration.Url).Msg("Skipping deregistration, waiting for the next retry.")
+		} else if !found {
+			if err := agent.Deregister(ctx, currentRegistration); err != nil {
+				failure := failures.failed(operationDeregister, currentRegistration, err)
+				logFailure(failure)
+				recordEvent(currentRegistration, corev1.EventTypeWarning, reasonDeregistrationFailed, fmt.Sprintf("Failed to deregister extension %s: %s", currentRegistration.Url, err))
 				combinedError = errors.Join(combinedError, err)
+				continue
 			}
-			if resp.IsError() {
-				err := fmt.Errorf("failed to deregister extension: %v. Status: %s", currentRegistration, resp.Status())
-				log.Error().Msg(err.Error())
-				combinedError = errors.Join(combinedError, err)
-			}
-			if resp.IsSuccess() {
-				log.Info().Msgf("De-Registered extension: %v", currentRegistration)
+			failures.succeeded(operationDeregister, currentRegistration)
+			removed = append(removed, currentRegistration)
+			metrics.RegistrationsRemoved.WithLabelValues(agentName).Inc()
+			owned.release(currentRegistration)
+			if slices.ContainsFunc(discoveredExtensions, func(e ExtensionConfigAO) bool { return e.Url == currentRegistration.Url }) {
+				// the url is still registered with the changed restrictions, the update is reported by addNewRegistrations
+				log.Info().Msgf("De-Registered outdated extension registration: %v", currentRegistration)
+				continue
 			}
+			log.Info().Msgf("De-Registered extension: %v", currentRegistration)
+			recordEvent(currentRegistration, corev1.EventTypeNormal, reasonDeregistered, fmt.Sprintf("Deregistered extension %s", currentRegistration.Url))
 		}
 	}
-	return combinedError
+	return removed, combinedError
 }
 
-func addNewRegistrations(httpClient *resty.Client, currentRegistrations []ExtensionConfigAO, discoveredExtensions []ExtensionConfigAO) error {
+// addNewRegistrations registers the discovered extensions missing at the agent. Registrations with a known url but changed
+// restrictions are registered again, the outdated registration is deregistered by removeMissingRegistrations. Failed
+// registrations are retried with backoff, only the failures of this attempt are returned together with the registered
+// extensions.
+func addNewRegistrations(ctx context.Context, agentName string, agent AgentClient, owned *ownership, failures *registrationFailures, currentRegistrations []ExtensionConfigAO, discoveredExtensions []ExtensionConfigAO, recordEvent eventRecorder) ([]ExtensionConfigAO, error) {
 	var combinedError error
+	added := make([]ExtensionConfigAO, 0)
 
 	for _, discoveredExtension := range discoveredExtensions {
 		found := false
+		update := false
 		for _, currentRegistration := range currentRegistrations {
 			if extensionsEqual(currentRegistration, discoveredExtension) {
+				if owned.adopts() {
+					// without a state file, registrations from previous runs are indistinguishable from our own
+					owned.own(discoveredExtension)
+				}
 				found = true
 				break
 			}
+			update = update || currentRegistration.Url == discoveredExtension.Url
 		}
-		if !found {
-			resp, err := httpClient.R().
-				SetHeader("Content-Type", "application/json").
-				SetBasicAuth("_", extensionconfig.Config.AgentKey).
-				SetBody(discoveredExtension).
-				Post("/extensions")
-			if err != nil {
-				log.Error().Err(err).Msgf("Failed to register extension: %v", discoveredExtension)
-				combinedError = errors.Join(combinedError, err)
-			}
-			if resp.IsError() {
-				err := fmt.Errorf("failed to register extension: %v. Status: %s", discoveredExtension, resp.Status())
-				log.Error().Msg(err.Error())
-				combinedError = errors.Join(combinedError, err)
-			}
-			if resp.IsSuccess() {
-				log.Info().Msgf("Registered extension: %v", discoveredExtension)
-			}
+		if found {
+			failures.succeeded(operationRegister, discoveredExtension)
+			continue
+		}
+		if !failures.shouldAttempt(operationRegister, discoveredExtension) {
+			log.Trace().Str("url", discoveredExtension.Url).Msg("Skipping registration, waiting for the next retry.")
+			continue
+		}
+		if err := agent.Register(ctx, discoveredExtension); err != nil {
+			failure := failures.failed(operationRegister, discoveredExtension, err)
+			logFailure(failure)
+			recordEvent(discoveredExtension, corev1.EventTypeWarning, reasonRegistrationFailed, fmt.Sprintf("Failed to register extension %s: %s", discoveredExtension.Url, err))
+			combinedError = errors.Join(combinedError, err)
+			continue
+		}
+		failures.succeeded(operationRegister, discoveredExtension)
+		owned.own(discoveredExtension)
+		added = append(added, discoveredExtension)
+		metrics.RegistrationsAdded.WithLabelValues(agentName).Inc()
+		if update {
+			log.Info().Msgf("Updated extension registration: %v", discoveredExtension)
+			recordEvent(discoveredExtension, corev1.EventTypeNormal, reasonRegistered, fmt.Sprintf("Updated registration of extension %s", discoveredExtension.Url))
+		} else {
+			log.Info().Msgf("Registered extension: %v", discoveredExtension)
+			recordEvent(discoveredExtension, corev1.EventTypeNormal, reasonRegistered, fmt.Sprintf("Registered extension %s", discoveredExtension.Url))
 		}
 	}
 
-	return combinedError
+	return added, combinedError
+}
+
+func logFailure(failure registrationFailure) {
+	if failure.permanent {
+		log.Error().Err(failure.err).Str("url", failure.registration.Url).Str("operation", failure.operation).Msg("Agent rejected the request permanently. Not retrying until the registration changes.")
+	} else {
+		log.Error().Err(failure.err).Str("url", failure.registration.Url).Str("operation", failure.operation).Int("attempts", failure.attempts).Time("retryAt", failure.retryAt).Msg("Agent request failed. Retrying with backoff.")
+	}
 }
 
 func extensionsEqual(a, b ExtensionConfigAO) bool {
@@ -114,9 +169,16 @@ func extensionsEqual(a, b ExtensionConfigAO) bool {
 	if !compareRestrictedIps(a.RestrictedIps, b.RestrictedIps) {
 		return false
 	}
+	if !compareTypes(a.Types, b.Types) {
+		return false
+	}
 	return true
 }
 
+func compareTypes(a, b []string) bool {
+	return slices.Equal(slices.Sorted(slices.Values(a)), slices.Sorted(slices.Values(b)))
+}
+
 func compareRestrictedPorts(a, b map[int]string) bool {
 	if len(a) != len(b) {
 		return false
@@ -1,7 +1,12 @@
 package autoregistration
 
 import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -97,6 +102,38 @@ func TestExtensionsEqual(t *testing.T) {
 			},
 			expected: true,
 		},
+		{
+			name: "extensions with different types should not be equal",
+			a: ExtensionConfigAO{
+				Url:             "http://test.example.com:8080",
+				Types:           []string{"host"},
+				RestrictedPorts: map[int]string{8080: "ContainerPort"},
+				RestrictedIps:   []string{"192.168.1.1"},
+			},
+			b: ExtensionConfigAO{
+				Url:             "http://test.example.com:8080",
+				Types:           []string{"container"},
+				RestrictedPorts: map[int]string{8080: "ContainerPort"},
+				RestrictedIps:   []string{"192.168.1.1"},
+			},
+			expected: false,
+		},
+		{
+			name: "extensions with same types in different order should be equal",
+			a: ExtensionConfigAO{
+				Url:             "http://test.example.com:8080",
+				Types:           []string{"host", "container"},
+				RestrictedPorts: map[int]string{8080: "ContainerPort"},
+				RestrictedIps:   []string{"192.168.1.1"},
+			},
+			b: ExtensionConfigAO{
+				Url:             "http://test.example.com:8080",
+				Types:           []string{"container", "host"},
+				RestrictedPorts: map[int]string{8080: "ContainerPort"},
+				RestrictedIps:   []string{"192.168.1.1"},
+			},
+			expected: true,
+		},
 		{
 			name: "extensions with empty restricted ports should be equal",
 			a: ExtensionConfigAO{
@@ -217,3 +254,31 @@ func TestExtensionsEqual(t *testing.T) {
 		})
 	}
 }
+
+func TestWaitForAgent(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	agent := newTestAgentClient(t, AgentClientOptions{Url: server.URL, Timeout: time.Second})
+	err := waitForAgent(context.Background(), agent, time.Second, 10*time.Millisecond, 20*time.Millisecond)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(3), requests.Load())
+}
+
+func TestWaitForAgentTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	agent := newTestAgentClient(t, AgentClientOptions{Url: server.URL, Timeout: time.Second})
+	err := waitForAgent(context.Background(), agent, 50*time.Millisecond, 10*time.Millisecond, 20*time.Millisecond)
+	assert.ErrorContains(t, err, "503")
+}
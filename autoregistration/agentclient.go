@@ -0,0 +1,219 @@
+package autoregistration
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/rs/zerolog/log"
+	"github.com/steadybit/extension-auto-registration-kubernetes/metrics"
+)
+
+const operationList = "list"
+
+// AgentClient manages the extension registrations of an agent.
+type AgentClient interface {
+	// List returns the extensions currently registered at the agent.
+	List(ctx context.Context) ([]ExtensionConfigAO, error)
+	// Register registers the extension. An existing registration with the same url is not replaced, it has to be
+	// deregistered on its own.
+	Register(ctx context.Context, extension ExtensionConfigAO) error
+	// Deregister removes the registration of the extension.
+	Deregister(ctx context.Context, extension ExtensionConfigAO) error
+}
+
+// AgentError is returned by the AgentClient if a request failed. StatusCode is 0 if the agent did not answer at all.
+type AgentError struct {
+	Operation  string
+	StatusCode int
+	Status     string
+	Err        error
+}
+
+func (e *AgentError) Error() string {
+	if e.StatusCode > 0 {
+		return fmt.Sprintf("agent rejected %s: %s", e.Operation, e.Status)
+	}
+	return fmt.Sprintf("agent request %s failed: %s", e.Operation, e.Err)
+}
+
+func (e *AgentError) Unwrap() error {
+	return e.Err
+}
+
+// AgentClientOptions configures the http communication with the agent.
+type AgentClientOptions struct {
+	// Name identifies the agent in the agent error metric.
+	Name string
+	// Url is the base url of the agent api, e.g. http://localhost:42899. With a unix socket, only the scheme is used.
+	Url string
+	// UnixSocket is the path of the unix domain socket the agent api listens on. Optional.
+	UnixSocket string
+	// Key authenticates the registrations at the agent.
+	Key string
+	// KeyFile contains the key, e.g. from a mounted secret. Takes precedence over Key. Changes are used without restart.
+	KeyFile string
+	// TlsCaFile contains the PEM encoded certificates to verify the agent with. Optional, the system pool is used
+	// without.
+	TlsCaFile string
+	// TlsCertFile and TlsKeyFile contain the PEM encoded client certificate and key for mTLS. Optional.
+	TlsCertFile string
+	TlsKeyFile  string
+	// Timeout limits a single request, including the reading of the response.
+	Timeout time.Duration
+	// Retries is the number of retries of a list request failing with a connection error or server error.
+	// Registrations and deregistrations are retried by the registration backoff instead.
+	Retries int
+}
+
+// restyAgentClient implements the AgentClient with the agent's http api.
+type restyAgentClient struct {
+	name       string
+	httpClient *resty.Client
+	key        *agentKey
+}
+
+// NewAgentClient creates an AgentClient for the agent api at the url of the options. The http client is configured with
+// the timeout, the retries and a transport reusing connections.
+func NewAgentClient(options AgentClientOptions) (AgentClient, error) {
+	key, err := newAgentKey(options.Key, options.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	transport, err := agentTransport(options)
+	if err != nil {
+		return nil, err
+	}
+	httpClient := resty.New().
+		SetBaseURL(options.Url).
+		SetDisableWarn(true).
+		SetTransport(transport).
+		SetTimeout(options.Timeout).
+		SetRetryCount(options.Retries).
+		SetRetryWaitTime(250 * time.Millisecond).
+		SetRetryMaxWaitTime(2 * time.Second).
+		AddRetryCondition(func(resp *resty.Response, err error) bool {
+			// registrations and deregistrations are not idempotent, they are retried by the registration backoff
+			if resp == nil || resp.Request == nil || resp.Request.Method != http.MethodGet {
+				return false
+			}
+			// a cancelled context must not be retried
+			if err != nil {
+				return !errors.Is(err, context.Canceled)
+			}
+			return resp.StatusCode() >= 500 || resp.StatusCode() == http.StatusTooManyRequests
+		})
+	return &restyAgentClient{name: options.Name, httpClient: httpClient, key: key}, nil
+}
+
+// agentTransport creates the transport to the agent. Connections are dialed via the unix socket if configured, TLS is
+// verified with the CA file and presents the client certificate if configured.
+func agentTransport(options AgentClientOptions) (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = 10
+	dialer := &net.Dialer{Timeout: 5 * time.Second, KeepAlive: 30 * time.Second}
+	transport.DialContext = dialer.DialContext
+	if options.UnixSocket != "" {
+		transport.Proxy = nil
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "unix", options.UnixSocket)
+		}
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if options.TlsCaFile != "" {
+		ca, err := os.ReadFile(options.TlsCaFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read agent CA file: %w", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificates found in agent CA file %s", options.TlsCaFile)
+		}
+	}
+	if options.TlsCertFile != "" || options.TlsKeyFile != "" {
+		certificate, err := tls.LoadX509KeyPair(options.TlsCertFile, options.TlsKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load agent client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+	transport.TLSClientConfig = tlsConfig
+	return transport, nil
+}
+
+func (c *restyAgentClient) List(ctx context.Context) ([]ExtensionConfigAO, error) {
+	var currentRegistrations *[]ExtensionConfigAO
+	resp, err := c.httpClient.R().
+		SetContext(ctx).
+		SetHeader("Accept", "application/json").
+		SetResult(&currentRegistrations).
+		Get("/extensions")
+	if err := agentError(c.name, operationList, resp, err); err != nil {
+		log.Error().Err(err).Msg("Failed to get extension registrations from the agent. Skip.")
+		return nil, err
+	}
+	if currentRegistrations == nil {
+		log.Trace().Msg("No extension registrations found on the agent")
+		return []ExtensionConfigAO{}, nil
+	}
+	log.Trace().Int("count", len(*currentRegistrations)).Msg("Got extension registrations from the agent")
+	return *currentRegistrations, nil
+}
+
+func (c *restyAgentClient) Register(ctx context.Context, extension ExtensionConfigAO) error {
+	return c.send(ctx, operationRegister, http.MethodPost, extension)
+}
+
+func (c *restyAgentClient) Deregister(ctx context.Context, extension ExtensionConfigAO) error {
+	return c.send(ctx, operationDeregister, http.MethodDelete, extension)
+}
+
+// send sends the extension to the agent. If the agent rejects the key, the key is read again and the request is
+// retried once if the key changed, as the key may have been rotated in between.
+func (c *restyAgentClient) send(ctx context.Context, operation string, method string, extension ExtensionConfigAO) error {
+	resp, err := c.request(ctx, method, extension)
+	if err == nil && resp.StatusCode() == http.StatusUnauthorized && c.key.reload() {
+		log.Debug().Str("operation", operation).Msg("Agent rejected the key. Retrying with the current key.")
+		resp, err = c.request(ctx, method, extension)
+	}
+	return agentError(c.name, operation, resp, err)
+}
+
+func (c *restyAgentClient) request(ctx context.Context, method string, extension ExtensionConfigAO) (*resty.Response, error) {
+	return c.httpClient.R().
+		SetContext(ctx).
+		SetHeader("Content-Type", "application/json").
+		SetBasicAuth("_", c.key.get()).
+		SetBody(extension).
+		Execute(method, "/extensions")
+}
+
+// metricOperations maps the operations to the operation label of the agent error metric
+var metricOperations = map[string]string{
+	operationList:       "get",
+	operationRegister:   "add",
+	operationDeregister: "delete",
+}
+
+// agentError converts the outcome of a request to an AgentError and counts it for the agent. It returns nil for
+// successful requests.
+func agentError(agentName string, operation string, resp *resty.Response, err error) error {
+	if err != nil {
+		metrics.AgentErrors.WithLabelValues(agentName, metricOperations[operation], "error").Inc()
+		return &AgentError{Operation: operation, Err: err}
+	}
+	if resp.IsError() {
+		metrics.AgentErrors.WithLabelValues(agentName, metricOperations[operation], strconv.Itoa(resp.StatusCode())).Inc()
+		return &AgentError{Operation: operation, StatusCode: resp.StatusCode(), Status: resp.Status()}
+	}
+	return nil
+}
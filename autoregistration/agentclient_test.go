@@ -0,0 +1,392 @@
+package autoregistration
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/steadybit/extension-auto-registration-kubernetes/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeAgentClient keeps the registrations in memory. Registrations with a url contained in rejected fail with the
+// given error, all requests fail while unreachable is set. onList is called with the context of each list request, if
+// set. A deregistration removes the equal registration, or all registrations of the url if deleteByUrl is set.
+type fakeAgentClient struct {
+	mu            sync.Mutex
+	registrations []ExtensionConfigAO
+	rejected      map[string]error
+	unreachable   bool
+	deleteByUrl   bool
+	onList        func(ctx context.Context)
+}
+
+func (f *fakeAgentClient) List(ctx context.Context) ([]ExtensionConfigAO, error) {
+	if f.onList != nil {
+		f.onList(ctx)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.unreachable {
+		return nil, &AgentError{Operation: operationList, Err: errors.New("connection refused")}
+	}
+	return append([]ExtensionConfigAO{}, f.registrations...), nil
+}
+
+func (f *fakeAgentClient) Register(_ context.Context, extension ExtensionConfigAO) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.unreachable {
+		return &AgentError{Operation: operationRegister, Err: errors.New("connection refused")}
+	}
+	if err := f.rejected[extension.Url]; err != nil {
+		return err
+	}
+	f.registrations = append(f.registrations, extension)
+	return nil
+}
+
+func (f *fakeAgentClient) Deregister(_ context.Context, extension ExtensionConfigAO) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.unreachable {
+		return &AgentError{Operation: operationDeregister, Err: errors.New("connection refused")}
+	}
+	if err := f.rejected[extension.Url]; err != nil {
+		return err
+	}
+	if f.deleteByUrl {
+		f.registrations = slices.DeleteFunc(f.registrations, func(registration ExtensionConfigAO) bool {
+			return registration.Url == extension.Url
+		})
+		return nil
+	}
+	for i, registration := range f.registrations {
+		if extensionsEqual(registration, extension) {
+			f.registrations = append(f.registrations[:i], f.registrations[i+1:]...)
+			return nil
+		}
+	}
+	return nil
+}
+
+func (f *fakeAgentClient) setUnreachable(unreachable bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.unreachable = unreachable
+}
+
+// urls returns the urls of the registrations.
+func (f *fakeAgentClient) urls() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	result := make([]string, 0, len(f.registrations))
+	for _, registration := range f.registrations {
+		result = append(result, registration.Url)
+	}
+	return result
+}
+
+func newTestAgentClient(t *testing.T, options AgentClientOptions) AgentClient {
+	agent, err := NewAgentClient(options)
+	require.NoError(t, err)
+	return agent
+}
+
+func noopEventRecorder(ExtensionConfigAO, string, string, string) {}
+
+func TestReconcileWithAgentClient(t *testing.T) {
+	kept := ExtensionConfigAO{Url: "http://kept:8080"}
+	obsolete := ExtensionConfigAO{Url: "http://obsolete:8080"}
+	foreign := ExtensionConfigAO{Url: "http://foreign:8080"}
+	added := ExtensionConfigAO{Url: "http://added:8080"}
+	rejected := ExtensionConfigAO{Url: "http://rejected:8080"}
+
+	agent := &fakeAgentClient{
+		registrations: []ExtensionConfigAO{kept, obsolete, foreign},
+		rejected:      map[string]error{rejected.Url: &AgentError{Operation: operationRegister, StatusCode: http.StatusBadRequest, Status: "400 Bad Request"}},
+	}
+	owned := newOwnership("", nil)
+	owned.own(kept)
+	owned.own(obsolete)
+	failures := newRegistrationFailures(time.Second, time.Minute)
+	discovered := []ExtensionConfigAO{kept, added, rejected}
+	ctx := context.Background()
+
+	current, err := agent.List(ctx)
+	require.NoError(t, err)
+	registered, err := addNewRegistrations(ctx, "sync-test", agent, owned, failures, current, discovered, noopEventRecorder)
+	assert.ErrorContains(t, err, "400 Bad Request")
+	assert.Equal(t, []ExtensionConfigAO{added}, registered)
+	deregistered, err := removeMissingRegistrations(ctx, "sync-test", agent, owned, failures, current, discovered, noopEventRecorder)
+	assert.NoError(t, err)
+	assert.Equal(t, []ExtensionConfigAO{obsolete}, deregistered)
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.RegistrationsAdded.WithLabelValues("sync-test")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.RegistrationsRemoved.WithLabelValues("sync-test")))
+
+	assert.ElementsMatch(t, []ExtensionConfigAO{kept, foreign, added}, agent.registrations)
+	assert.True(t, owned.isOwned(added))
+	assert.False(t, owned.isOwned(obsolete))
+	assert.False(t, failures.shouldAttempt(operationRegister, rejected), "permanently rejected registration must not be retried")
+}
+
+func TestReplaceOutdatedRegistration(t *testing.T) {
+	outdated := ExtensionConfigAO{Url: "http://extension:8080", RestrictedPorts: map[int]string{8080: "ContainerPort"}}
+	updated := ExtensionConfigAO{Url: "http://extension:8080", RestrictedPorts: map[int]string{8080: "ContainerPort", 8081: "LivenessProbe"}}
+
+	agent := &fakeAgentClient{registrations: []ExtensionConfigAO{outdated}}
+	owned := newOwnership("", nil)
+	owned.own(outdated)
+	failures := newRegistrationFailures(time.Second, time.Minute)
+	discovered := []ExtensionConfigAO{updated}
+	ctx := context.Background()
+
+	current, err := agent.List(ctx)
+	require.NoError(t, err)
+	_, err = removeMissingRegistrations(ctx, "default", agent, owned, failures, current, discovered, noopEventRecorder)
+	require.NoError(t, err)
+	_, err = addNewRegistrations(ctx, "default", agent, owned, failures, current, discovered, noopEventRecorder)
+	require.NoError(t, err)
+
+	assert.Equal(t, []ExtensionConfigAO{updated}, agent.registrations, "the outdated registration should be removed")
+	assert.True(t, owned.isOwned(updated))
+	assert.False(t, owned.isOwned(outdated))
+}
+
+func TestAdoptRegistrationsOnlyWithoutStateFile(t *testing.T) {
+	existing := ExtensionConfigAO{Url: "http://extension:8080"}
+	ctx := context.Background()
+
+	tests := []struct {
+		name      string
+		stateFile string
+		expected  bool
+	}{
+		{name: "without state file", stateFile: "", expected: true},
+		{name: "with state file", stateFile: filepath.Join(t.TempDir(), "state.json"), expected: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			agent := &fakeAgentClient{registrations: []ExtensionConfigAO{existing}}
+			owned := newOwnership(tt.stateFile, nil)
+			failures := newRegistrationFailures(time.Second, time.Minute)
+
+			added, err := addNewRegistrations(ctx, "default", agent, owned, failures, []ExtensionConfigAO{existing}, []ExtensionConfigAO{existing}, noopEventRecorder)
+			require.NoError(t, err)
+			assert.Empty(t, added)
+			assert.Equal(t, tt.expected, owned.isOwned(existing))
+			assert.Equal(t, []ExtensionConfigAO{existing}, agent.registrations, "the existing registration should not be registered again")
+		})
+	}
+}
+
+func TestAgentClientError(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if r.Method == http.MethodPost {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	agent := newTestAgentClient(t, AgentClientOptions{Name: "error-test", Key: "key", Url: server.URL, Timeout: time.Second, Retries: 2})
+
+	err := agent.Register(context.Background(), ExtensionConfigAO{Url: "http://extension:8080"})
+	var agentError *AgentError
+	require.ErrorAs(t, err, &agentError)
+	assert.Equal(t, operationRegister, agentError.Operation)
+	assert.Equal(t, http.StatusBadRequest, agentError.StatusCode)
+	assert.Equal(t, int32(1), requests.Load(), "client errors must not be retried")
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.AgentErrors.WithLabelValues("error-test", "add", "400")))
+
+	requests.Store(0)
+	err = agent.Deregister(context.Background(), ExtensionConfigAO{Url: "http://extension:8080"})
+	require.ErrorAs(t, err, &agentError)
+	assert.Equal(t, http.StatusServiceUnavailable, agentError.StatusCode)
+	assert.Equal(t, int32(1), requests.Load(), "deregistrations must be left to the registration backoff")
+
+	requests.Store(0)
+	_, err = agent.List(context.Background())
+	require.ErrorAs(t, err, &agentError)
+	assert.Equal(t, http.StatusServiceUnavailable, agentError.StatusCode)
+	assert.Equal(t, int32(3), requests.Load(), "server errors of list requests must be retried")
+}
+
+func TestAgentClientTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer server.Close()
+	agent := newTestAgentClient(t, AgentClientOptions{Key: "key", Url: server.URL, Timeout: 50 * time.Millisecond})
+
+	_, err := agent.List(context.Background())
+	var agentError *AgentError
+	require.ErrorAs(t, err, &agentError)
+	assert.Equal(t, 0, agentError.StatusCode)
+	assert.Error(t, errors.Unwrap(err))
+}
+
+func TestAgentClientUnixSocket(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "agent.sock")
+	listener, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`[{"url":"http://extension:8080"}]`))
+	}))
+	server.Listener = listener
+	server.Start()
+	defer server.Close()
+	agent := newTestAgentClient(t, AgentClientOptions{Key: "key", Url: "http://localhost", UnixSocket: socket, Timeout: time.Second})
+
+	registrations, err := agent.List(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []ExtensionConfigAO{{Url: "http://extension:8080"}}, registrations)
+}
+
+func TestAgentClientMutualTls(t *testing.T) {
+	dir := t.TempDir()
+	clientCert, clientKey := writeTestCertificate(t, dir)
+	clientCertificate, err := tls.LoadX509KeyPair(clientCert, clientKey)
+	require.NoError(t, err)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCertificate.Leaf)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	server.StartTLS()
+	defer server.Close()
+	caFile := filepath.Join(dir, "ca.pem")
+	require.NoError(t, os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0o600))
+
+	agent := newTestAgentClient(t, AgentClientOptions{Key: "key", Url: server.URL, TlsCaFile: caFile, TlsCertFile: clientCert, TlsKeyFile: clientKey, Timeout: time.Second})
+	_, err = agent.List(context.Background())
+	assert.NoError(t, err)
+
+	withoutCertificate := newTestAgentClient(t, AgentClientOptions{Key: "key", Url: server.URL, TlsCaFile: caFile, Timeout: time.Second})
+	_, err = withoutCertificate.List(context.Background())
+	assert.Error(t, err)
+
+	withoutCa := newTestAgentClient(t, AgentClientOptions{Key: "key", Url: server.URL, TlsCertFile: clientCert, TlsKeyFile: clientKey, Timeout: time.Second})
+	_, err = withoutCa.List(context.Background())
+	assert.ErrorContains(t, err, "certificate")
+}
+
+func TestAgentClientInvalidCaFile(t *testing.T) {
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(caFile, []byte("no certificate"), 0o600))
+
+	_, err := NewAgentClient(AgentClientOptions{Key: "key", Url: "https://agent:42899", TlsCaFile: caFile})
+	assert.ErrorContains(t, err, "no certificates found")
+}
+
+// writeTestCertificate writes a self-signed client certificate and its key to the directory.
+func writeTestCertificate(t *testing.T, dir string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "auto-registration"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certFile := filepath.Join(dir, "client.pem")
+	keyFile := filepath.Join(dir, "client-key.pem")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0o600))
+	return certFile, keyFile
+}
+
+func TestAgentClientKeyRotation(t *testing.T) {
+	var expectedKey atomic.Value
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if _, key, _ := r.BasicAuth(); key != expectedKey.Load() {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	keyFile := filepath.Join(t.TempDir(), "agent-key")
+	require.NoError(t, os.WriteFile(keyFile, []byte("key-a\n"), 0o600))
+	agent := newTestAgentClient(t, AgentClientOptions{Key: "ignored", KeyFile: keyFile, Url: server.URL, Timeout: time.Second})
+	extension := ExtensionConfigAO{Url: "http://extension:8080"}
+
+	expectedKey.Store("key-a")
+	assert.NoError(t, agent.Register(context.Background(), extension))
+
+	// a changed file is used for the next request
+	expectedKey.Store("rotated-key-b")
+	require.NoError(t, os.WriteFile(keyFile, []byte("rotated-key-b\n"), 0o600))
+	assert.NoError(t, agent.Register(context.Background(), extension))
+
+	// a change not visible in the file's metadata is picked up after the agent rejected the key
+	info, err := os.Stat(keyFile)
+	require.NoError(t, err)
+	expectedKey.Store("rotated-key-c")
+	require.NoError(t, os.WriteFile(keyFile, []byte("rotated-key-c\n"), 0o600))
+	require.NoError(t, os.Chtimes(keyFile, info.ModTime(), info.ModTime()))
+	requests.Store(0)
+	assert.NoError(t, agent.Deregister(context.Background(), extension))
+	assert.Equal(t, int32(2), requests.Load())
+
+	// the request is not retried if the key did not change
+	expectedKey.Store("unknown")
+	requests.Store(0)
+	err = agent.Register(context.Background(), extension)
+	var agentError *AgentError
+	require.ErrorAs(t, err, &agentError)
+	assert.Equal(t, http.StatusUnauthorized, agentError.StatusCode)
+	assert.Equal(t, int32(1), requests.Load())
+
+	// the request is retried only once
+	info, err = os.Stat(keyFile)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(keyFile, []byte("rotated-key-d\n"), 0o600))
+	require.NoError(t, os.Chtimes(keyFile, info.ModTime(), info.ModTime()))
+	requests.Store(0)
+	err = agent.Register(context.Background(), extension)
+	require.ErrorAs(t, err, &agentError)
+	assert.Equal(t, http.StatusUnauthorized, agentError.StatusCode)
+	assert.Equal(t, int32(2), requests.Load())
+}
+
+func TestAgentClientMissingKeyFile(t *testing.T) {
+	_, err := NewAgentClient(AgentClientOptions{KeyFile: filepath.Join(t.TempDir(), "missing"), Url: "http://localhost:42899"})
+	assert.ErrorContains(t, err, "failed to read agent key file")
+}
@@ -0,0 +1,90 @@
+package autoregistration
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// agentKey provides the key to authenticate at the agent. The key is either static or read from a file. The file is
+// checked before each request and read again once it changed, e.g. after the rotation of a mounted secret.
+type agentKey struct {
+	file string
+
+	mu      sync.Mutex
+	value   string
+	modTime time.Time
+	size    int64
+}
+
+// newAgentKey returns the static key or, if a file is given, the key read from the file.
+func newAgentKey(key string, file string) (*agentKey, error) {
+	k := &agentKey{file: file, value: key}
+	if file != "" {
+		if err := k.read(); err != nil {
+			return nil, err
+		}
+	}
+	return k, nil
+}
+
+// get returns the current key. The key file is read again if its modification time or size changed.
+func (k *agentKey) get() string {
+	if k.file == "" {
+		return k.value
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	info, err := os.Stat(k.file)
+	if err != nil {
+		log.Warn().Err(err).Str("file", k.file).Msg("Failed to check the agent key file. Using the previous key.")
+		return k.value
+	}
+	if !info.ModTime().Equal(k.modTime) || info.Size() != k.size {
+		if err := k.read(); err != nil {
+			log.Warn().Err(err).Msg("Failed to read the agent key file. Using the previous key.")
+		}
+	}
+	return k.value
+}
+
+// reload reads the key file again, regardless of its modification time. It returns true if the key changed.
+func (k *agentKey) reload() bool {
+	if k.file == "" {
+		return false
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	previous := k.value
+	if err := k.read(); err != nil {
+		log.Warn().Err(err).Msg("Failed to read the agent key file. Using the previous key.")
+	}
+	return k.value != previous
+}
+
+// read reads the key file. The caller must hold the lock, unless the key is not shared yet.
+func (k *agentKey) read() error {
+	info, err := os.Stat(k.file)
+	if err != nil {
+		return fmt.Errorf("failed to read agent key file: %w", err)
+	}
+	content, err := os.ReadFile(k.file)
+	if err != nil {
+		return fmt.Errorf("failed to read agent key file: %w", err)
+	}
+	value := strings.TrimSpace(string(content))
+	if value == "" {
+		return fmt.Errorf("agent key file %s is empty", k.file)
+	}
+	if k.value != "" && k.value != value {
+		log.Info().Str("file", k.file).Msg("Agent key changed, using the new key.")
+	}
+	k.value = value
+	k.modTime = info.ModTime()
+	k.size = info.Size()
+	return nil
+}
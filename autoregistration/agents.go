@@ -0,0 +1,124 @@
+package autoregistration
+
+import (
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// Agent is an agent the discovered extensions are registered at.
+type Agent struct {
+	// Name identifies the agent in logs, events, metrics and the status endpoint.
+	Name   string
+	Client AgentClient
+	// Namespaces limits the extensions registered at the agent to the given namespaces. Optional.
+	Namespaces []string
+	// MatchLabels limits the extensions registered at the agent to pods and services matching the selector. Optional.
+	MatchLabels labels.Selector
+	// StateFile persists the registrations owned at the agent. Optional.
+	StateFile string
+}
+
+// agentTarget is the sync state of an agent. Each agent has its own sync queue, ownership, failures and backoff, an
+// unreachable agent does therefore not delay the others.
+type agentTarget struct {
+	name         string
+	agent        AgentClient
+	namespaces   []string
+	matchLabels  labels.Selector
+	owned        *ownership
+	failures     *registrationFailures
+	missingSince map[string]time.Time
+	// sources contains the pod or service of the discovered urls, to record the events of their deregistration
+	sources *sync.Map
+	// queue contains the pending sync of the agent, keyed by the agent name. Requests for a pending sync are coalesced,
+	// requests during a sync are processed once the sync is done.
+	queue       workqueue.TypedRateLimitingInterface[string]
+	rateLimiter workqueue.TypedRateLimiter[string]
+	// changes counts the discovered changes, syncedChanges the changes synced to the agent
+	changes       atomic.Uint64
+	syncedChanges atomic.Uint64
+	syncMutex     sync.Mutex
+	// lastSuccessfulSync is the unix time in nanoseconds, 0 if there was no successful sync yet
+	lastSuccessfulSync    atomic.Int64
+	lastSyncSucceeded     atomic.Bool
+	lastRegistrationCount int
+	// view is the registrations of the agent as of the last sync, served by the status endpoint
+	view atomic.Pointer[agentView]
+}
+
+// agentView is the registrations of an agent after a sync. If the agent could not be reached, err is set and the
+// registrations are those of the last sync reaching the agent.
+type agentView struct {
+	registrations []ExtensionConfigAO
+	syncedAt      time.Time
+	err           error
+}
+
+func newAgentTarget(agent Agent, protectedUrls []string, failureInterval time.Duration, failureMaxInterval time.Duration) *agentTarget {
+	// the rate limiter backs off the syncs while the agent is not reachable
+	rateLimiter := workqueue.NewTypedItemExponentialFailureRateLimiter[string](failureInterval, failureMaxInterval)
+	return &agentTarget{
+		name:         agent.Name,
+		agent:        agent.Client,
+		namespaces:   agent.Namespaces,
+		matchLabels:  agent.MatchLabels,
+		owned:        newOwnership(agent.StateFile, protectedUrls),
+		failures:     newRegistrationFailures(failureInterval, failureMaxInterval),
+		missingSince: make(map[string]time.Time),
+		sources:      &sync.Map{},
+		queue:        workqueue.NewTypedRateLimitingQueueWithConfig(rateLimiter, workqueue.TypedRateLimitingQueueConfig[string]{Name: "agent-" + agent.Name}),
+		rateLimiter:  rateLimiter,
+	}
+}
+
+// isDirty reports whether discovered changes are not yet synced to the agent.
+func (t *agentTarget) isDirty() bool {
+	return t.changes.Load() != t.syncedChanges.Load()
+}
+
+// accepts reports whether the extension is registered at the agent, i.e. it was discovered in one of the namespaces of
+// the agent and its pod or service matches the label selector of the agent.
+func (t *agentTarget) accepts(extension ExtensionConfigAO) bool {
+	if len(t.namespaces) > 0 && (extension.source == nil || !slices.Contains(t.namespaces, extension.source.Namespace)) {
+		return false
+	}
+	if t.matchLabels != nil && !t.matchLabels.Empty() && !t.matchLabels.Matches(labels.Set(extension.sourceLabels)) {
+		return false
+	}
+	return true
+}
+
+// acceptedExtensions returns the extensions registered at the agent.
+func (t *agentTarget) acceptedExtensions(extensions []ExtensionConfigAO) []ExtensionConfigAO {
+	return slices.DeleteFunc(slices.Clone(extensions), func(extension ExtensionConfigAO) bool {
+		return !t.accepts(extension)
+	})
+}
+
+// lastSync returns the time of the last successful sync, the zero time if there was none yet.
+func (t *agentTarget) lastSync() time.Time {
+	if nanos := t.lastSuccessfulSync.Load(); nanos != 0 {
+		return time.Unix(0, nanos)
+	}
+	return time.Time{}
+}
+
+// storeView stores the registrations of the agent after the sync started at the given time.
+func (t *agentTarget) storeView(registrations []ExtensionConfigAO, syncedAt time.Time) {
+	t.view.Store(&agentView{registrations: registrations, syncedAt: syncedAt})
+}
+
+// storeViewError stores that the agent could not be reached, keeping the registrations of the last sync.
+func (t *agentTarget) storeViewError(err error) {
+	view := agentView{err: err}
+	if previous := t.view.Load(); previous != nil {
+		view.registrations = previous.registrations
+		view.syncedAt = previous.syncedAt
+	}
+	t.view.Store(&view)
+}
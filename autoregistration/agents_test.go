@@ -0,0 +1,299 @@
+package autoregistration
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/steadybit/extension-auto-registration-kubernetes/client"
+	"github.com/steadybit/extension-auto-registration-kubernetes/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	testclient "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestAgentTargetAccepts(t *testing.T) {
+	extension := ExtensionConfigAO{
+		Url:          "http://extension:8080",
+		source:       &corev1.ObjectReference{Kind: "Pod", Namespace: "tenant-a", Name: "extension"},
+		sourceLabels: map[string]string{"team": "a"},
+	}
+	tests := []struct {
+		name     string
+		agent    Agent
+		expected bool
+	}{
+		{name: "without filter", agent: Agent{}, expected: true},
+		{name: "matching namespace", agent: Agent{Namespaces: []string{"tenant-b", "tenant-a"}}, expected: true},
+		{name: "other namespace", agent: Agent{Namespaces: []string{"tenant-b"}}, expected: false},
+		{name: "matching labels", agent: Agent{MatchLabels: labels.SelectorFromSet(labels.Set{"team": "a"})}, expected: true},
+		{name: "other labels", agent: Agent{MatchLabels: labels.SelectorFromSet(labels.Set{"team": "b"})}, expected: false},
+		{name: "empty selector", agent: Agent{MatchLabels: labels.NewSelector()}, expected: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := newAgentTarget(tt.agent, nil, time.Second, time.Minute)
+			assert.Equal(t, tt.expected, target.accepts(extension))
+		})
+	}
+}
+
+func TestMarkDirtyCoalescesSyncs(t *testing.T) {
+	registrator := NewAutoRegistrationForAgents([]Agent{{Name: "default", Client: &fakeAgentClient{}}}, nil)
+	registrator.agentRegistrationInterval = 0
+	target := registrator.targets[0]
+	defer target.queue.ShutDown()
+
+	registrator.markDirty()
+	registrator.markDirty()
+	registrator.markDirty()
+	assert.Eventually(t, func() bool { return target.queue.Len() == 1 }, time.Second, 10*time.Millisecond)
+	assert.True(t, target.isDirty())
+}
+
+func TestChangesDuringSyncAreNotLost(t *testing.T) {
+	agent := &fakeAgentClient{}
+	registrator := NewAutoRegistrationForAgents([]Agent{{Name: "default", Client: agent}}, nil)
+	target := registrator.targets[0]
+	defer target.queue.ShutDown()
+
+	agent.onList = func(context.Context) {
+		// discovered while the sync is in progress
+		registrator.discoveredExtensions.Store("default/late", []ExtensionConfigAO{{Url: "http://late:8080"}})
+		registrator.markDirty()
+	}
+	registrator.markDirty()
+	registrator.syncRegistrations(target)
+	assert.True(t, target.isDirty(), "A change during the sync should be synced again")
+
+	agent.onList = nil
+	registrator.syncRegistrations(target)
+	assert.False(t, target.isDirty())
+	assert.Equal(t, []ExtensionConfigAO{{Url: "http://late:8080"}}, agent.registrations)
+}
+
+func TestRegistrationsStatusIsServedFromLastSync(t *testing.T) {
+	registered := ExtensionConfigAO{Url: "http://registered:8080"}
+	stale := ExtensionConfigAO{Url: "http://stale:8080"}
+	agent := &fakeAgentClient{registrations: []ExtensionConfigAO{stale}}
+	registrator := NewAutoRegistrationForAgents([]Agent{{Name: "default", Client: agent}}, nil)
+	target := registrator.targets[0]
+	defer target.queue.ShutDown()
+	target.owned.own(stale)
+
+	assert.Nil(t, registrator.Registrations().SyncedAt, "there should be no agent view before the first sync")
+
+	registrator.discoveredExtensions.Store("default/registered", []ExtensionConfigAO{registered})
+	registrator.markDirty()
+	registrator.syncRegistrations(target)
+
+	agent.onList = func(context.Context) {
+		t.Error("the status should not request the agent")
+	}
+	status := registrator.Registrations()
+	assert.Equal(t, []ExtensionConfigAO{registered}, status.Agent, "the view should contain the changes of the sync")
+	assert.NotNil(t, status.SyncedAt)
+	assert.Empty(t, status.Pending.Add)
+	assert.Empty(t, status.Pending.Remove)
+
+	// a failed sync keeps the registrations of the last sync
+	agent.onList = nil
+	agent.setUnreachable(true)
+	registrator.markDirty()
+	registrator.syncRegistrations(target)
+	failedStatus := registrator.Registrations()
+	assert.NotEmpty(t, failedStatus.AgentError)
+	assert.Equal(t, []ExtensionConfigAO{registered}, failedStatus.Agent)
+	assert.Equal(t, status.SyncedAt, failedStatus.SyncedAt)
+}
+
+func TestMakeBeforeBreakDeregistersStaleUrlsDespiteFailedRegistrations(t *testing.T) {
+	failing := ExtensionConfigAO{Url: "http://failing:8080"}
+	stale := ExtensionConfigAO{Url: "http://stale:8080"}
+	agent := &fakeAgentClient{
+		registrations: []ExtensionConfigAO{stale},
+		rejected:      map[string]error{failing.Url: &AgentError{Operation: operationRegister, StatusCode: http.StatusServiceUnavailable, Status: "503 Service Unavailable"}},
+	}
+	registrator := NewAutoRegistrationForAgents([]Agent{{Name: "default", Client: agent}}, nil)
+	registrator.agentRegistrationStrategy = config.StrategyMakeBeforeBreak
+	target := registrator.targets[0]
+	defer target.queue.ShutDown()
+	target.owned.own(stale)
+
+	registrator.discoveredExtensions.Store("default/failing", []ExtensionConfigAO{failing})
+	registrator.markDirty()
+	registrator.syncRegistrations(target)
+
+	assert.Empty(t, agent.registrations, "the stale registration should be removed although another registration failed")
+	failures := target.failures.list()
+	require.Len(t, failures, 1)
+	assert.False(t, failures[0].permanent, "the failed registration should be retried")
+}
+
+func TestReplaceOutdatedRegistrationAtAgentDeletingByUrl(t *testing.T) {
+	outdated := ExtensionConfigAO{Url: "http://extension:8080", RestrictedPorts: map[int]string{8080: "ContainerPort"}}
+	updated := ExtensionConfigAO{Url: "http://extension:8080", RestrictedPorts: map[int]string{8080: "ContainerPort", 8081: "LivenessProbe"}}
+
+	for _, strategy := range []string{config.StrategyMakeBeforeBreak, config.StrategyBreakBeforeMake} {
+		t.Run(strategy, func(t *testing.T) {
+			agent := &fakeAgentClient{registrations: []ExtensionConfigAO{outdated}, deleteByUrl: true}
+			registrator := NewAutoRegistrationForAgents([]Agent{{Name: "default", Client: agent}}, nil)
+			registrator.agentRegistrationStrategy = strategy
+			target := registrator.targets[0]
+			defer target.queue.ShutDown()
+			target.owned.own(outdated)
+
+			registrator.discoveredExtensions.Store("default/extension", []ExtensionConfigAO{updated})
+			registrator.markDirty()
+			registrator.syncRegistrations(target)
+
+			assert.Equal(t, []ExtensionConfigAO{updated}, agent.registrations, "the updated registration should survive the deletion of the outdated one")
+			assert.True(t, target.owned.isOwned(updated))
+		})
+	}
+}
+
+func TestDeregistrationKeepsSourceOfOtherAgents(t *testing.T) {
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	registrator := NewAutoRegistrationForAgents([]Agent{{Name: "a", Client: &fakeAgentClient{}}, {Name: "b", Client: &fakeAgentClient{}}}, client.CreateClient(testclient.NewSimpleClientset(), stopCh))
+	a, b := registrator.targets[0], registrator.targets[1]
+	source := &corev1.ObjectReference{Kind: "Pod", Namespace: "default", Name: "extension"}
+	a.sources.Store("http://extension:8080", source)
+	b.sources.Store("http://extension:8080", source)
+
+	registrator.eventRecorderFor(a)(ExtensionConfigAO{Url: "http://extension:8080"}, corev1.EventTypeNormal, reasonDeregistered, "Deregistered extension")
+
+	_, ok := a.sources.Load("http://extension:8080")
+	assert.False(t, ok, "The source should be forgotten by the deregistering agent")
+	value, ok := b.sources.Load("http://extension:8080")
+	assert.True(t, ok, "The source should be kept for the other agents")
+	assert.Equal(t, source, value)
+}
+
+func TestMultipleAgents(t *testing.T) {
+	config.Config.DefaultHealthPort = 8081
+	config.Config.PreferredIpFamily = config.IpFamilyPrimary
+	config.Config.AgentRegistrationInterval = 100 * time.Millisecond
+	config.Config.AgentRegistrationIntervalAfterError = 100 * time.Millisecond
+	config.Config.AgentRegistrationMaxIntervalAfterError = 200 * time.Millisecond
+	// the unreachable agent must not delay the others while it is waited for
+	config.Config.AgentReadinessTimeout = 1 * time.Minute
+	config.Config.AgentReadinessInterval = 100 * time.Millisecond
+	config.Config.AgentReadinessMaxInterval = 200 * time.Millisecond
+	defer func() { config.Config = config.Specification{} }()
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	clientset := testclient.NewSimpleClientset()
+	k8sClient := client.CreateClient(clientset, stopCh)
+
+	all := &fakeAgentClient{}
+	tenant := &fakeAgentClient{}
+	labelled := &fakeAgentClient{}
+	unreachable := &fakeAgentClient{unreachable: true}
+	registrator := NewAutoRegistrationForAgents([]Agent{
+		{Name: "all", Client: all},
+		{Name: "tenant", Client: tenant, Namespaces: []string{"tenant-a"}},
+		{Name: "labelled", Client: labelled, MatchLabels: labels.SelectorFromSet(labels.Set{"team": "a"})},
+		{Name: "unreachable", Client: unreachable},
+	}, k8sClient)
+	registrator.Start()
+	defer registrator.Stop(false)
+
+	for _, pod := range []*corev1.Pod{
+		extensionPod("default", "192.168.1.1", nil),
+		extensionPod("tenant-a", "192.168.1.2", map[string]string{"team": "a"}),
+	} {
+		_, err := clientset.CoreV1().Pods(pod.Namespace).Create(context.Background(), pod, metav1.CreateOptions{})
+		require.NoError(t, err, "Pod creation should succeed")
+	}
+
+	assert.EventuallyWithT(t, func(c *assert.CollectT) {
+		assert.ElementsMatch(c, []string{"http://192.168.1.1:8080", "http://192.168.1.2:8080"}, all.urls())
+		assert.ElementsMatch(c, []string{"http://192.168.1.2:8080"}, tenant.urls())
+		assert.ElementsMatch(c, []string{"http://192.168.1.2:8080"}, labelled.urls())
+	}, 5*time.Second, 100*time.Millisecond, "The reachable agents should be synced despite the unreachable agent")
+	assert.True(t, registrator.IsDirty(), "The unreachable agent should not be synced")
+	assert.False(t, registrator.IsReady(), "The unreachable agent should affect the readiness")
+
+	status := registrator.Registrations()
+	assert.Equal(t, "all", status.Name)
+	assert.Len(t, status.Agents, 4)
+	assert.Empty(t, status.Agents[1].AgentError)
+	assert.Len(t, status.Agents[1].Agent, 1)
+	assert.NotNil(t, status.Agents[1].SyncedAt)
+	assert.Nil(t, status.Agents[3].SyncedAt, "The unreachable agent should have no synced registrations")
+	assert.Empty(t, status.Agents[3].Agent)
+
+	// the agent catches up once it is reachable
+	unreachable.setUnreachable(false)
+	assert.EventuallyWithT(t, func(c *assert.CollectT) {
+		assert.ElementsMatch(c, []string{"http://192.168.1.1:8080", "http://192.168.1.2:8080"}, unreachable.urls())
+	}, 5*time.Second, 100*time.Millisecond)
+	assert.Eventually(t, func() bool { return !registrator.IsDirty() }, 5*time.Second, 100*time.Millisecond)
+}
+
+// extensionPod returns a running and ready pod annotated with an extension on port 8080.
+func extensionPod(namespace string, ip string, podLabels map[string]string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "extension",
+			Namespace:   namespace,
+			Labels:      podLabels,
+			Annotations: map[string]string{extensionAnnotationKey: `{"extensions":[{"port":8080,"protocol":"http"}]}`},
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{Name: "extension", Ports: []corev1.ContainerPort{{ContainerPort: 8080}}}},
+		},
+		Status: corev1.PodStatus{
+			Phase:      corev1.PodRunning,
+			PodIP:      ip,
+			Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}},
+		},
+	}
+}
+
+func TestStopWaitsForInFlightSync(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	aborted := make(chan bool, 1)
+	agent := &fakeAgentClient{}
+	agent.onList = func(ctx context.Context) {
+		close(started)
+		<-release
+		aborted <- ctx.Err() != nil
+	}
+	registrator := NewAutoRegistrationForAgents([]Agent{{Name: "default", Client: agent}}, nil)
+	registrator.markDirty()
+	go registrator.syncRegistrations(registrator.targets[0])
+	<-started
+
+	stopped := make(chan struct{})
+	go func() {
+		registrator.Stop(false)
+		close(stopped)
+	}()
+	assert.Never(t, func() bool {
+		select {
+		case <-stopped:
+			return true
+		default:
+			return false
+		}
+	}, 200*time.Millisecond, 10*time.Millisecond, "Stop should wait for the in-flight sync")
+
+	close(release)
+	assert.False(t, <-aborted, "The in-flight agent request should not be aborted")
+	assert.Eventually(t, func() bool {
+		select {
+		case <-stopped:
+			return true
+		default:
+			return false
+		}
+	}, time.Second, 10*time.Millisecond)
+}
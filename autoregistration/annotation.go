@@ -0,0 +1,150 @@
+package autoregistration
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	annotationVersionLegacy = 1
+	annotationVersion2      = 2
+)
+
+const (
+	// extensionAnnotationKey is the annotation of pods and services registering extensions
+	extensionAnnotationKey = "steadybit.com/extension-auto-registration"
+	// namespaceDisabledKey is the annotation or label disabling the discovery in a namespace if set to "true"
+	namespaceDisabledKey = "steadybit.com/extension-auto-registration-disabled"
+	// namespaceDefaultsKey is the namespace annotation with the defaults for all extensions of the namespace
+	namespaceDefaultsKey = "steadybit.com/extension-auto-registration-defaults"
+)
+
+// parseAnnotationJSON parses and validates the value of the extension annotation. The defaults (if any) are merged under
+// each extension. Invalid entries are skipped and reported in the returned error, the valid entries are returned
+// nevertheless.
+func parseAnnotationJSON(value string, defaults *ExtensionAnnotation) ([]ExtensionAnnotation, error) {
+	var extAnnotations ExtensionAnnotations
+	if err := json.Unmarshal([]byte(value), &extAnnotations); err != nil {
+		return []ExtensionAnnotation{}, fmt.Errorf("invalid json: %w", err)
+	}
+
+	version := extAnnotations.Version
+	if version == 0 {
+		version = annotationVersionLegacy
+	}
+	if version != annotationVersionLegacy && version != annotationVersion2 {
+		return []ExtensionAnnotation{}, fmt.Errorf("unsupported version %d, supported versions are %d and %d", extAnnotations.Version, annotationVersionLegacy, annotationVersion2)
+	}
+
+	var errs []error
+	result := make([]ExtensionAnnotation, 0, len(extAnnotations.Extensions))
+	names := make(map[string]bool)
+	for i, annotation := range extAnnotations.Extensions {
+		if version < annotationVersion2 && usesVersion2Fields(annotation) {
+			// these fields were ignored before version 2, dropping the entry would break annotations working so far
+			log.Warn().Str("annotation", value).Msgf("extensions[%d]: name, types, enabled, restrictedPorts and restrictedIps require version %d. Ignoring them.", i, annotationVersion2)
+			annotation = withoutVersion2Fields(annotation)
+		}
+		if defaults != nil {
+			annotation = mergeDefaults(annotation, *defaults)
+		}
+		if err := validateAnnotation(annotation, true); err != nil {
+			errs = append(errs, fmt.Errorf("extensions[%d]: %w", i, err))
+			continue
+		}
+		if annotation.Name != "" {
+			if names[annotation.Name] {
+				errs = append(errs, fmt.Errorf("extensions[%d]: duplicate name %q", i, annotation.Name))
+				continue
+			}
+			names[annotation.Name] = true
+		}
+		result = append(result, annotation)
+	}
+	return result, errors.Join(errs...)
+}
+
+// parseDefaultsJSON parses and validates the namespace defaults, a single extension entry without required fields.
+func parseDefaultsJSON(value string) (*ExtensionAnnotation, error) {
+	var defaults ExtensionAnnotation
+	if err := json.Unmarshal([]byte(value), &defaults); err != nil {
+		return nil, fmt.Errorf("invalid json: %w", err)
+	}
+	if defaults.Name != "" {
+		return nil, errors.New("name must not be set in defaults")
+	}
+	if err := validateAnnotation(defaults, false); err != nil {
+		return nil, err
+	}
+	return &defaults, nil
+}
+
+// mergeDefaults returns the annotation with all unset fields taken from the defaults.
+func mergeDefaults(annotation ExtensionAnnotation, defaults ExtensionAnnotation) ExtensionAnnotation {
+	if annotation.Protocol == "" {
+		annotation.Protocol = defaults.Protocol
+	}
+	if annotation.Port == 0 {
+		annotation.Port = defaults.Port
+	}
+	if annotation.Path == "" {
+		annotation.Path = defaults.Path
+	}
+	if len(annotation.Types) == 0 {
+		annotation.Types = defaults.Types
+	}
+	if annotation.Enabled == nil {
+		annotation.Enabled = defaults.Enabled
+	}
+	if len(annotation.RestrictedPorts) == 0 {
+		annotation.RestrictedPorts = defaults.RestrictedPorts
+	}
+	if len(annotation.RestrictedIps) == 0 {
+		annotation.RestrictedIps = defaults.RestrictedIps
+	}
+	return annotation
+}
+
+func usesVersion2Fields(annotation ExtensionAnnotation) bool {
+	return annotation.Name != "" || len(annotation.Types) > 0 || annotation.Enabled != nil || len(annotation.RestrictedPorts) > 0 || len(annotation.RestrictedIps) > 0
+}
+
+// withoutVersion2Fields returns the annotation with all fields introduced by version 2 unset.
+func withoutVersion2Fields(annotation ExtensionAnnotation) ExtensionAnnotation {
+	return ExtensionAnnotation{Protocol: annotation.Protocol, Port: annotation.Port, Path: annotation.Path}
+}
+
+func validateAnnotation(annotation ExtensionAnnotation, requireProtocol bool) error {
+	var errs []error
+	if (requireProtocol || annotation.Protocol != "") && annotation.Protocol != "http" && annotation.Protocol != "https" {
+		errs = append(errs, fmt.Errorf("protocol must be http or https, got %q", annotation.Protocol))
+	}
+	if annotation.Port < 0 || annotation.Port > 65535 {
+		errs = append(errs, fmt.Errorf("port %d is out of range", annotation.Port))
+	}
+	if annotation.Path != "" && !strings.HasPrefix(annotation.Path, "/") {
+		errs = append(errs, fmt.Errorf("path %q must start with /", annotation.Path))
+	}
+	for _, t := range annotation.Types {
+		if strings.TrimSpace(t) == "" {
+			errs = append(errs, errors.New("types must not contain empty values"))
+			break
+		}
+	}
+	for _, port := range annotation.RestrictedPorts {
+		if port <= 0 || port > 65535 {
+			errs = append(errs, fmt.Errorf("restricted port %d is out of range", port))
+		}
+	}
+	for _, ip := range annotation.RestrictedIps {
+		if net.ParseIP(ip) == nil {
+			errs = append(errs, fmt.Errorf("restricted ip %q is not a valid ip address", ip))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,99 @@
+package autoregistration
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAnnotationJSON(t *testing.T) {
+	tests := []struct {
+		name          string
+		value         string
+		defaults      *ExtensionAnnotation
+		expected      []ExtensionAnnotation
+		expectedError string
+	}{
+		{
+			name:     "legacy format",
+			value:    `{"extensions":[{"port":8080,"protocol":"http","path":"/ext"}]}`,
+			expected: []ExtensionAnnotation{{Protocol: "http", Port: 8080, Path: "/ext"}},
+		},
+		{
+			name:  "version 2",
+			value: `{"version":2,"extensions":[{"name":"host","port":8085,"protocol":"https","types":["host"],"enabled":false,"restrictedPorts":[9090],"restrictedIps":["10.0.0.1"]}]}`,
+			expected: []ExtensionAnnotation{{
+				Name:            "host",
+				Protocol:        "https",
+				Port:            8085,
+				Types:           []string{"host"},
+				Enabled:         new(false),
+				RestrictedPorts: []int{9090},
+				RestrictedIps:   []string{"10.0.0.1"},
+			}},
+		},
+		{
+			name:          "invalid json",
+			value:         `{"extensions":[`,
+			expected:      []ExtensionAnnotation{},
+			expectedError: "invalid json",
+		},
+		{
+			name:          "unsupported version",
+			value:         `{"version":3,"extensions":[{"port":8080,"protocol":"http"}]}`,
+			expected:      []ExtensionAnnotation{},
+			expectedError: "unsupported version 3",
+		},
+		{
+			name:     "version 2 fields in legacy format are ignored",
+			value:    `{"extensions":[{"name":"host","port":8080,"protocol":"http","types":["host"],"enabled":false,"restrictedPorts":[9090],"restrictedIps":["not-an-ip"]}]}`,
+			expected: []ExtensionAnnotation{{Protocol: "http", Port: 8080}},
+		},
+		{
+			name:          "invalid entries are skipped",
+			value:         `{"version":2,"extensions":[{"port":8080,"protocol":"ftp"},{"port":8081,"protocol":"http","restrictedIps":["not-an-ip"]},{"port":8082,"protocol":"http"}]}`,
+			expected:      []ExtensionAnnotation{{Protocol: "http", Port: 8082}},
+			expectedError: "extensions[0]: protocol must be http or https, got \"ftp\"\nextensions[1]: restricted ip \"not-an-ip\" is not a valid ip address",
+		},
+		{
+			name:     "defaults are merged under the extensions",
+			value:    `{"extensions":[{"port":8080},{"port":8081,"protocol":"https","path":"/ext"}]}`,
+			defaults: &ExtensionAnnotation{Protocol: "http", Path: "/", Types: []string{"host"}},
+			expected: []ExtensionAnnotation{
+				{Protocol: "http", Port: 8080, Path: "/", Types: []string{"host"}},
+				{Protocol: "https", Port: 8081, Path: "/ext", Types: []string{"host"}},
+			},
+		},
+		{
+			name:          "duplicate names",
+			value:         `{"version":2,"extensions":[{"name":"a","port":8080,"protocol":"http"},{"name":"a","port":8081,"protocol":"http"}]}`,
+			expected:      []ExtensionAnnotation{{Name: "a", Protocol: "http", Port: 8080}},
+			expectedError: "extensions[1]: duplicate name \"a\"",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := parseAnnotationJSON(tt.value, tt.defaults)
+			if tt.expectedError != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.expectedError)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.expected, result)
+		})
+	}
+}
+
+func TestParseDefaultsJSON(t *testing.T) {
+	defaults, err := parseDefaultsJSON(`{"protocol":"https","types":["host"]}`)
+	require.NoError(t, err)
+	assert.Equal(t, &ExtensionAnnotation{Protocol: "https", Types: []string{"host"}}, defaults)
+
+	_, err = parseDefaultsJSON(`{"path":"ext"}`)
+	assert.ErrorContains(t, err, "path \"ext\" must start with /")
+
+	_, err = parseDefaultsJSON(`{"name":"a"}`)
+	assert.ErrorContains(t, err, "name must not be set in defaults")
+}
@@ -1,7 +1,8 @@
 package autoregistration
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
 	"maps"
 	"slices"
@@ -11,182 +12,528 @@ import (
 	"sync/atomic"
 	"time"
 
-	"github.com/go-resty/resty/v2"
 	"github.com/rs/zerolog/log"
 	"github.com/steadybit/extension-auto-registration-kubernetes/client"
 	"github.com/steadybit/extension-auto-registration-kubernetes/config"
+	"github.com/steadybit/extension-auto-registration-kubernetes/metrics"
 	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
 )
 
+// shutdownTimeout limits the wait for in-flight syncs and the deregistration of all extensions on shutdown
+const shutdownTimeout = 30 * time.Second
+
 type AutoRegistration struct {
-	httpClient                          *resty.Client
-	k8sClient                           *client.Client
-	discoveredExtensions                *sync.Map
-	isDirty                             atomic.Bool
-	agentRegistrationInterval           time.Duration
-	agentRegistrationIntervalAfterError time.Duration
-	matchLabels                         config.Labels
-	matchLabelsExclude                  config.Labels
+	// ctx is cancelled once Stop is done, or earlier to abort agent requests exceeding the shutdown timeout
+	ctx                                    context.Context
+	cancel                                 context.CancelFunc
+	targets                                []*agentTarget
+	k8sClient                              *client.Client
+	discoveredExtensions                   *sync.Map
+	discoveredServiceExtensions            *sync.Map
+	stopped                                atomic.Bool
+	agentRegistrationInterval              time.Duration
+	agentRegistrationIntervalAfterError    time.Duration
+	agentRegistrationReconcileInterval     time.Duration
+	agentRegistrationStrategy              string
+	agentDeregistrationGracePeriod         time.Duration
+	matchLabels                            labels.Selector
+	matchLabelsExclude                     labels.Selector
+	nodeName                               string
+	defaultHealthPort                      int
+	ipFamily                               string
+	registerViaHostPort                    bool
+	agentRegistrationMaxIntervalAfterError time.Duration
+}
+
+// UpdateAgentExtensions creates the auto registration and starts to sync the discovered extensions to the agent.
+func UpdateAgentExtensions(agent AgentClient, k8sClient *client.Client) *AutoRegistration {
+	registrator := NewAutoRegistration(agent, k8sClient)
+	registrator.Start()
+	return registrator
+}
+
+// NewAutoRegistration creates the auto registration for a single agent.
+func NewAutoRegistration(agent AgentClient, k8sClient *client.Client) *AutoRegistration {
+	return NewAutoRegistrationForAgents([]Agent{{Name: "default", Client: agent, StateFile: config.Config.StateFile}}, k8sClient)
 }
 
-func UpdateAgentExtensions(httpClient *resty.Client, k8sClient *client.Client) *AutoRegistration {
+// NewAutoRegistrationForAgents creates the auto registration syncing the discovered extensions to all agents.
+func NewAutoRegistrationForAgents(agents []Agent, k8sClient *client.Client) *AutoRegistration {
+	ctx, cancel := context.WithCancel(context.Background())
 	registrator := AutoRegistration{
-		httpClient:                          httpClient,
-		k8sClient:                           k8sClient,
-		discoveredExtensions:                &sync.Map{},
-		agentRegistrationInterval:           config.Config.AgentRegistrationInterval,
-		agentRegistrationIntervalAfterError: config.Config.AgentRegistrationIntervalAfterError,
-		matchLabels:                         config.Config.MatchLabels,
-		matchLabelsExclude:                  config.Config.MatchLabelsExclude,
-		isDirty:                             atomic.Bool{},
-	}
-
-	registrator.syncRegistrations()
-	k8sClient.WatchPods(registrator.processAddedPod, registrator.processUpdatedPod, registrator.processDeletedPod)
-	k8sClient.WatchServices(registrator.processAddedService, registrator.processUpdatedService, registrator.processDeletedService)
+		ctx:                                    ctx,
+		cancel:                                 cancel,
+		k8sClient:                              k8sClient,
+		discoveredExtensions:                   &sync.Map{},
+		discoveredServiceExtensions:            &sync.Map{},
+		agentRegistrationInterval:              config.Config.AgentRegistrationInterval,
+		agentRegistrationIntervalAfterError:    config.Config.AgentRegistrationIntervalAfterError,
+		agentRegistrationReconcileInterval:     config.Config.AgentRegistrationReconcileInterval,
+		agentRegistrationStrategy:              config.Config.AgentRegistrationStrategy,
+		agentDeregistrationGracePeriod:         config.Config.AgentDeregistrationGracePeriod,
+		matchLabels:                            mustSelector(config.Config.MatchLabels),
+		matchLabelsExclude:                     mustSelector(config.Config.MatchLabelsExclude),
+		defaultHealthPort:                      config.Config.DefaultHealthPort,
+		ipFamily:                               config.Config.PreferredIpFamily,
+		registerViaHostPort:                    config.Config.RegisterViaHostPort,
+		agentRegistrationMaxIntervalAfterError: config.Config.AgentRegistrationMaxIntervalAfterError,
+	}
+	for _, agent := range agents {
+		registrator.targets = append(registrator.targets, newAgentTarget(agent, config.Config.ProtectedUrls, config.Config.AgentRegistrationIntervalAfterError, config.Config.AgentRegistrationMaxIntervalAfterError))
+	}
+	if config.Config.NodeLocalRegistration {
+		registrator.nodeName = config.Config.NodeName
+	}
 	return &registrator
 }
 
+// Start starts the processing of Kubernetes events and the sync queues. The first sync waits until the existing objects
+// are discovered, otherwise the registrations of existing pods and services would be removed and added again. Each queue
+// waits for its agent to become ready on its own, an unreachable agent does not delay the others.
+func (r *AutoRegistration) Start() {
+	start := time.Now()
+	r.k8sClient.WatchPods(r.processAddedPod, r.processUpdatedPod, r.processDeletedPod)
+	if r.k8sClient.ServiceDiscoveryEnabled() {
+		r.k8sClient.WatchServicePods(r.processAddedServicePod, r.processUpdatedServicePod, r.processDeletedServicePod)
+		r.k8sClient.WatchServices(r.processAddedService, r.processUpdatedService, r.processDeletedService)
+		r.k8sClient.WatchEndpointSlices(r.processAddedEndpointSlice, r.processUpdatedEndpointSlice, r.processDeletedEndpointSlice)
+	}
+	r.k8sClient.WatchNamespaces(r.processUpdatedNamespace)
+	if !cache.WaitForCacheSync(r.ctx.Done(), r.k8sClient.HandlersSynced) {
+		log.Warn().Msg("Stopped before the existing pods and services were discovered.")
+		return
+	}
+
+	// the first sync also removes owned registrations which are not discovered anymore
+	r.markDirty()
+	for _, t := range r.targets {
+		t.queue.Add(t.name)
+		go func() {
+			r.awaitAgent(t, start)
+			r.processQueue(t)
+		}()
+	}
+}
+
+// awaitAgent blocks until the agent answers, the readiness timeout is exceeded or the auto registration is stopped. The
+// configured initial delay is respected as a minimum.
+func (r *AutoRegistration) awaitAgent(t *agentTarget, start time.Time) {
+	log.Info().Str("agent", t.name).Float64("timeoutSeconds", config.Config.AgentReadinessTimeout.Seconds()).Msg("Waiting for the agent to become ready.")
+	err := waitForAgent(r.ctx, t.agent, config.Config.AgentReadinessTimeout, config.Config.AgentReadinessInterval, config.Config.AgentReadinessMaxInterval)
+	if err != nil {
+		log.Warn().Err(err).Str("agent", t.name).Msg("Agent is not ready, starting the registration anyway.")
+	}
+	if remaining := config.Config.AgentRegistrationInitialDelay - time.Since(start); remaining > 0 {
+		log.Info().Str("agent", t.name).Float64("seconds", remaining.Seconds()).Msg("Waiting for the remaining initial delay before starting the registration.")
+		select {
+		case <-r.ctx.Done():
+		case <-time.After(remaining):
+		}
+	}
+}
+
+// Stop stops the sync queues and waits for in-flight syncs to finish their agent requests. If deregister is true, all
+// registrations owned by the auto registration are removed from the agents afterward. Agent requests still pending
+// after the shutdown timeout are aborted.
+func (r *AutoRegistration) Stop(deregister bool) {
+	r.stopped.Store(true)
+	for _, t := range r.targets {
+		t.queue.ShutDown()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	context.AfterFunc(ctx, r.cancel)
+	var wg sync.WaitGroup
+	for _, t := range r.targets {
+		wg.Go(func() {
+			t.syncMutex.Lock()
+			defer t.syncMutex.Unlock()
+			if !deregister {
+				return
+			}
+			log.Info().Str("agent", t.name).Msg("Deregistering all owned extensions.")
+			currentRegistrations, err := t.agent.List(ctx)
+			if err == nil {
+				// a pending backoff must not prevent the final deregistration
+				failures := newRegistrationFailures(r.agentRegistrationIntervalAfterError, r.agentRegistrationMaxIntervalAfterError)
+				_, err = removeMissingRegistrations(ctx, t.name, t.agent, t.owned, failures, currentRegistrations, []ExtensionConfigAO{}, r.eventRecorderFor(t))
+			}
+			if err != nil {
+				log.Error().Err(err).Str("agent", t.name).Msg("Failed to deregister extensions on shutdown.")
+			}
+		})
+	}
+	wg.Wait()
+}
+
+// IsDirty reports whether discovered changes are not yet synced to all agents.
 func (r *AutoRegistration) IsDirty() bool {
-	return r.isDirty.Load()
+	return slices.ContainsFunc(r.targets, (*agentTarget).isDirty)
 }
 
-func (r *AutoRegistration) processAddedPod(pod *corev1.Pod) {
-	extensions := r.toExtensionConfigs(pod)
-	if len(extensions) > 0 {
-		log.Debug().Str("pod", pod.Name).Str("namespace", pod.Namespace).Int("count", len(extensions)).Msg("Pod added / extensions found.")
-		r.discoveredExtensions.Store(r.key(pod), extensions)
-		r.isDirty.Store(true)
+// markDirty queues a sync of all agents. The first change queues the sync after the registration interval, further
+// changes join the queued sync without delaying it. A burst of changes is therefore synced at most one interval after its
+// first change, even if the burst is still ongoing.
+func (r *AutoRegistration) markDirty() {
+	for _, t := range r.targets {
+		t.changes.Add(1)
+		t.queue.AddAfter(t.name, r.agentRegistrationInterval)
 	}
+	metrics.SetDirty(true)
+}
+
+func (r *AutoRegistration) processAddedPod(pod *corev1.Pod) {
+	r.storeExtensions(r.discoveredExtensions, pod, r.toExtensionConfigs(pod, nil), "Pod added")
 }
 
 func (r *AutoRegistration) processUpdatedPod(_ *corev1.Pod, new *corev1.Pod) {
-	extensions := r.toExtensionConfigs(new)
+	r.storeExtensions(r.discoveredExtensions, new, r.toExtensionConfigs(new, nil), "Pod updated")
+}
+
+func (r *AutoRegistration) processDeletedPod(pod *corev1.Pod) {
+	r.deleteExtensions(r.discoveredExtensions, pod)
+}
+
+// processAddedServicePod, processUpdatedServicePod and processDeletedServicePod re-evaluate the services backed by the pod,
+// as changed labels or annotations of the pod might change the service-level registrations.
+func (r *AutoRegistration) processAddedServicePod(pod *corev1.Pod) {
+	r.processServicesOfPod(pod)
+}
+
+func (r *AutoRegistration) processUpdatedServicePod(_ *corev1.Pod, new *corev1.Pod) {
+	r.processServicesOfPod(new)
+}
+
+func (r *AutoRegistration) processDeletedServicePod(pod *corev1.Pod) {
+	r.processServicesOfPod(pod)
+}
+
+func (r *AutoRegistration) processServicesOfPod(pod *corev1.Pod) {
+	for _, service := range r.k8sClient.ServicesByPod(pod) {
+		r.processUpdatedService(nil, service)
+	}
+}
+
+func (r *AutoRegistration) storeExtensions(discovered *sync.Map, pod *corev1.Pod, extensions []ExtensionConfigAO, event string) {
 	if len(extensions) > 0 {
-		r.discoveredExtensions.Store(r.key(new), extensions)
-		log.Debug().Str("pod", new.Name).Str("namespace", new.Namespace).Int("count", len(extensions)).Msg("Pod updated / extensions found.")
-		r.isDirty.Store(true)
+		discovered.Store(r.key(pod), extensions)
+		log.Debug().Str("pod", pod.Name).Str("namespace", pod.Namespace).Int("count", len(extensions)).Msgf("%s / extensions found.", event)
+		r.markDirty()
 	} else {
-		value, loaded := r.discoveredExtensions.LoadAndDelete(r.key(new))
+		value, loaded := discovered.LoadAndDelete(r.key(pod))
 		if loaded {
 			v := value.([]ExtensionConfigAO)
-			log.Debug().Str("pod", new.Name).Str("namespace", new.Namespace).Int("count", len(v)).Msg("Pod updated / no extensions found anymore.")
-			r.isDirty.Store(true)
+			log.Debug().Str("pod", pod.Name).Str("namespace", pod.Namespace).Int("count", len(v)).Msgf("%s / no extensions found anymore.", event)
+			r.markDirty()
 		}
 	}
 }
 
-func (r *AutoRegistration) processDeletedPod(pod *corev1.Pod) {
-	value, loaded := r.discoveredExtensions.LoadAndDelete(r.key(pod))
+func (r *AutoRegistration) deleteExtensions(discovered *sync.Map, pod *corev1.Pod) {
+	value, loaded := discovered.LoadAndDelete(r.key(pod))
 	if loaded {
 		v := value.([]ExtensionConfigAO)
 		log.Debug().Str("pod", pod.Name).Str("namespace", pod.Namespace).Int("count", len(v)).Msg("Pod deleted / extension will be deregistered.")
-		r.isDirty.Store(true)
+		r.markDirty()
 	}
 }
 
 func (r *AutoRegistration) processAddedService(service *corev1.Service) {
-	pods := r.k8sClient.PodsByService(service)
-	for _, pod := range pods {
-		r.processUpdatedPod(nil, pod)
-	}
+	r.processUpdatedService(nil, service)
 }
 
-func (r *AutoRegistration) processUpdatedService(old *corev1.Service, new *corev1.Service) {
-	pods := r.k8sClient.PodsByService(old)
-	for _, pod := range pods {
-		r.processUpdatedPod(nil, pod)
-	}
-	pods = r.k8sClient.PodsByService(new)
-	for _, pod := range pods {
-		r.processUpdatedPod(nil, pod)
+func (r *AutoRegistration) processUpdatedService(_ *corev1.Service, new *corev1.Service) {
+	extensions := r.toServiceExtensionConfigs(new, nil)
+	key := r.serviceKey(new)
+	if len(extensions) > 0 {
+		r.discoveredServiceExtensions.Store(key, extensions)
+		log.Debug().Str("service", new.Name).Str("namespace", new.Namespace).Int("count", len(extensions)).Msg("Service updated / extensions found.")
+		r.markDirty()
+	} else {
+		value, loaded := r.discoveredServiceExtensions.LoadAndDelete(key)
+		if loaded {
+			v := value.([]ExtensionConfigAO)
+			log.Debug().Str("service", new.Name).Str("namespace", new.Namespace).Int("count", len(v)).Msg("Service updated / no extensions found anymore.")
+			r.markDirty()
+		}
 	}
 }
 
 func (r *AutoRegistration) processDeletedService(service *corev1.Service) {
-	pods := r.k8sClient.PodsByService(service)
-	for _, pod := range pods {
+	value, loaded := r.discoveredServiceExtensions.LoadAndDelete(r.serviceKey(service))
+	if loaded {
+		v := value.([]ExtensionConfigAO)
+		log.Debug().Str("service", service.Name).Str("namespace", service.Namespace).Int("count", len(v)).Msg("Service deleted / extension will be deregistered.")
+		r.markDirty()
+	}
+}
+
+func (r *AutoRegistration) processAddedEndpointSlice(endpointSlice *discoveryv1.EndpointSlice) {
+	r.processServiceOfEndpointSlice(endpointSlice)
+}
+
+func (r *AutoRegistration) processUpdatedEndpointSlice(_ *discoveryv1.EndpointSlice, new *discoveryv1.EndpointSlice) {
+	r.processServiceOfEndpointSlice(new)
+}
+
+func (r *AutoRegistration) processDeletedEndpointSlice(endpointSlice *discoveryv1.EndpointSlice) {
+	r.processServiceOfEndpointSlice(endpointSlice)
+}
+
+// processUpdatedNamespace re-evaluates all pods and services of the namespace if the namespace-level settings changed.
+func (r *AutoRegistration) processUpdatedNamespace(old *corev1.Namespace, new *corev1.Namespace) {
+	if isNamespaceDisabled(old) == isNamespaceDisabled(new) && old.Annotations[namespaceDefaultsKey] == new.Annotations[namespaceDefaultsKey] {
+		return
+	}
+	log.Debug().Str("namespace", new.Name).Msg("Namespace settings changed / re-evaluating pods and services.")
+	for _, pod := range r.k8sClient.PodsByNamespace(new.Name) {
 		r.processUpdatedPod(nil, pod)
 	}
+	for _, service := range r.k8sClient.ServicesByNamespace(new.Name) {
+		r.processUpdatedService(nil, service)
+	}
 }
 
-func workloadMatchesSelector(podLabel map[string]string, matchLabel []config.Label) bool {
-	for _, label := range matchLabel {
-		if value, exists := podLabel[label.Key]; !exists || value != label.Value {
-			return false
-		}
+func (r *AutoRegistration) processServiceOfEndpointSlice(endpointSlice *discoveryv1.EndpointSlice) {
+	// a deleted service is handled by processDeletedService
+	if service := r.k8sClient.ServiceByEndpointSlice(endpointSlice); service != nil {
+		r.processUpdatedService(nil, service)
 	}
-	return true
 }
 
-func (r *AutoRegistration) toExtensionConfigs(pod *corev1.Pod) []ExtensionConfigAO {
-	result := make([]ExtensionConfigAO, 0)
+func mustSelector(l config.Labels) labels.Selector {
+	selector, err := l.Selector()
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid label selector.")
+	}
+	return selector
+}
 
+func (r *AutoRegistration) isCandidate(pod *corev1.Pod, trace *decisionTrace) bool {
 	if !r.k8sClient.IsPodRunningAndReady(pod) {
 		log.Trace().Str("pod", pod.Name).Str("namespace", pod.Namespace).Msg("Exclude candidate because it is not running and ready.")
-		return result
+		trace.addFor(r.key(pod), "running and ready", false, string(pod.Status.Phase))
+		return false
 	}
-	if len(r.matchLabels) != 0 && !workloadMatchesSelector(pod.Labels, r.matchLabels) {
+	trace.addFor(r.key(pod), "running and ready", true, "")
+	return r.matchesLabelFilters(pod, trace)
+}
+
+func (r *AutoRegistration) matchesLabelFilters(pod *corev1.Pod, trace *decisionTrace) bool {
+	if !r.matchLabels.Empty() && !r.matchLabels.Matches(labels.Set(pod.Labels)) {
 		log.Trace().Str("pod", pod.Name).Str("namespace", pod.Namespace).Msg("Exclude candidate because it does not match matchLabels.")
-		return result
+		trace.addFor(r.key(pod), "matchLabels", false, r.matchLabels.String())
+		return false
+	} else if !r.matchLabels.Empty() {
+		trace.addFor(r.key(pod), "matchLabels", true, r.matchLabels.String())
 	}
-	if len(r.matchLabelsExclude) != 0 && workloadMatchesSelector(pod.Labels, r.matchLabelsExclude) {
+	if !r.matchLabelsExclude.Empty() && r.matchLabelsExclude.Matches(labels.Set(pod.Labels)) {
 		log.Trace().Str("pod", pod.Name).Str("namespace", pod.Namespace).Msg("Exclude candidate because it matches matchLabelsExclude.")
+		trace.addFor(r.key(pod), "matchLabelsExclude", false, r.matchLabelsExclude.String())
+		return false
+	} else if !r.matchLabelsExclude.Empty() {
+		trace.addFor(r.key(pod), "matchLabelsExclude", true, r.matchLabelsExclude.String())
+	}
+	return true
+}
+
+// toExtensionConfigs returns the extensions registered by the annotations of the pod itself.
+func (r *AutoRegistration) toExtensionConfigs(pod *corev1.Pod, trace *decisionTrace) []ExtensionConfigAO {
+	result := make([]ExtensionConfigAO, 0)
+
+	if !r.isCandidate(pod, trace) || !r.isNamespaceEnabled(pod.Namespace, trace) {
+		return result
+	}
+	if r.nodeName != "" && pod.Spec.NodeName != r.nodeName {
+		log.Trace().Str("pod", pod.Name).Str("namespace", pod.Namespace).Str("node", pod.Spec.NodeName).Msg("Exclude candidate because it is running on a different node.")
+		trace.add("node", false, pod.Spec.NodeName)
 		return result
+	} else if r.nodeName != "" {
+		trace.add("node", true, pod.Spec.NodeName)
 	}
 
-	podAnnotations := r.getExtensionAnnotations(pod.Annotations)
+	podAnnotations, err := r.getExtensionAnnotations(pod.Namespace, pod.Annotations)
+	if err != nil {
+		log.Warn().Err(err).Str("pod", pod.Name).Str("namespace", pod.Namespace).Msg("Invalid extension annotation. Ignoring invalid entries.")
+		r.recordDiscoveryEvent(trace, podReference(pod), corev1.EventTypeWarning, reasonInvalidAnnotation, err.Error())
+	}
+	trace.addAnnotation(podAnnotations, err)
 	if len(podAnnotations) > 0 {
-		podIP := pod.Status.PodIP
+		ips := podIPs(pod)
+		podIP := preferredIP(ips, r.ipFamily)
 		if podIP == "" {
 			log.Warn().Str("pod", pod.Name).Str("namespace", pod.Namespace).Msg("Pod has extension annotations but no IP. Ignoring.")
+			r.recordDiscoveryEvent(trace, podReference(pod), corev1.EventTypeWarning, reasonMissingPodIP, "Pod has extension annotations but no IP.")
+			trace.add("pod ip", false, "")
 			return result
 		}
+		trace.add("pod ip", true, podIP)
+
+		// the node ips are restricted as well if the pod is reachable via the node
+		nodeIPs := hostIPs(pod)
+		if usesHostNetworking(pod) {
+			trace.add("host networking", true, strings.Join(nodeIPs, ","))
+			for _, ip := range nodeIPs {
+				if !slices.Contains(ips, ip) {
+					ips = append(ips, ip)
+				}
+			}
+		}
+
 		for _, annotation := range podAnnotations {
-			url := fmt.Sprintf("%s://%s", annotation.Protocol, podIP)
-			if annotation.Port > 0 {
-				url += ":" + strconv.Itoa(annotation.Port)
+			ip, port := podIP, annotation.Port
+			if r.registerViaHostPort {
+				if hostPort, hostIP := hostPortOf(pod, annotation.Port), preferredIP(nodeIPs, r.ipFamily); hostPort > 0 && hostIP != "" {
+					ip, port = hostIP, hostPort
+				}
 			}
-			url += annotation.Path
 			result = append(result, ExtensionConfigAO{
-				Url:             url,
-				RestrictedPorts: r.getAdditionalPortsOfPod(pod),
-				RestrictedIps:   []string{podIP},
+				Name:            annotation.Name,
+				Url:             extensionUrl(annotation.Protocol, ip, port, annotation.Path),
+				Types:           annotation.Types,
+				RestrictedPorts: withAnnotationPorts(withPort(r.getAdditionalPortsOfPod(pod), annotation.Port, "AnnotationPort"), annotation),
+				RestrictedIps:   withAnnotationIps(ips, annotation),
+				source:          podReference(pod),
+				sourceLabels:    pod.Labels,
 			})
 		}
-	} else {
-		for _, service := range r.k8sClient.ServicesByPod(pod) {
-			log.Trace().Str("pod", pod.Name).Str("namespace", pod.Namespace).Str("service", service.Name).Msg("Found service for pod.")
-			serviceAnnotations := r.getExtensionAnnotations(service.Annotations)
-			if len(serviceAnnotations) > 0 {
-				restrictedPorts := make(map[int]string)
-				restrictedIps := make([]string, 0)
-				for _, s := range service.Spec.Ports {
-					restrictedPorts[int(s.Port)] = "ServicePort"
+	}
+	return result
+}
+
+// toServiceExtensionConfigs returns the extensions registered by the annotations of the service. There is one registration
+// per annotation entry, restricting the union of the IPs and ports of all ready endpoints.
+func (r *AutoRegistration) toServiceExtensionConfigs(service *corev1.Service, trace *decisionTrace) []ExtensionConfigAO {
+	result := make([]ExtensionConfigAO, 0)
+
+	if !r.isNamespaceEnabled(service.Namespace, trace) {
+		return result
+	}
+	serviceAnnotations, err := r.getExtensionAnnotations(service.Namespace, service.Annotations)
+	if err != nil {
+		log.Warn().Err(err).Str("service", service.Name).Str("namespace", service.Namespace).Msg("Invalid extension annotation. Ignoring invalid entries.")
+		r.recordDiscoveryEvent(trace, serviceReference(service), corev1.EventTypeWarning, reasonInvalidAnnotation, err.Error())
+	}
+	trace.addAnnotation(serviceAnnotations, err)
+	if len(serviceAnnotations) == 0 {
+		return result
+	}
+
+	endpoints := r.serviceEndpoints(service, trace)
+	if len(endpoints) == 0 {
+		log.Trace().Str("service", service.Name).Str("namespace", service.Namespace).Msg("Exclude service because it has no ready endpoints.")
+		trace.add("ready endpoints", false, "")
+		return result
+	}
+	trace.add("ready endpoints", true, strconv.Itoa(len(endpoints)))
+
+	restrictedPorts := make(map[int]string)
+	restrictedIps := make([]string, 0)
+	for _, s := range service.Spec.Ports {
+		restrictedPorts[int(s.Port)] = "ServicePort"
+	}
+	for _, ingress := range service.Status.LoadBalancer.Ingress {
+		if ingress.IP != "" {
+			restrictedIps = append(restrictedIps, ingress.IP)
+		}
+	}
+	restrictedIps = append(restrictedIps, clusterIPsOfService(service)...)
+	for _, endpoint := range endpoints {
+		mergeMaps(restrictedPorts, endpoint.ports)
+		for _, ip := range endpoint.ips {
+			if !slices.Contains(restrictedIps, ip) {
+				restrictedIps = append(restrictedIps, ip)
+			}
+		}
+	}
+
+	for _, annotation := range serviceAnnotations {
+		result = append(result, ExtensionConfigAO{
+			Name:            annotation.Name,
+			Url:             extensionUrl(annotation.Protocol, service.Name+"."+service.Namespace+".svc.cluster.local", annotation.Port, annotation.Path),
+			Types:           annotation.Types,
+			RestrictedIps:   withAnnotationIps(restrictedIps, annotation),
+			RestrictedPorts: withAnnotationPorts(restrictedPorts, annotation),
+			source:          serviceReference(service),
+			sourceLabels:    service.Labels,
+		})
+	}
+	return result
+}
+
+type serviceEndpoint struct {
+	ips   []string
+	ports map[int]string
+}
+
+// serviceEndpoints returns the ready endpoints of the service. Endpoints of the same pod (e.g. from the IPv4 and IPv6
+// endpoint slices of a dual-stack service) are merged. Endpoints without a pod reference are managed by hand and are
+// taken as they are.
+func (r *AutoRegistration) serviceEndpoints(service *corev1.Service, trace *decisionTrace) []*serviceEndpoint {
+	result := make([]*serviceEndpoint, 0)
+	byKey := make(map[string]*serviceEndpoint)
+
+	for _, endpointSlice := range r.k8sClient.EndpointSlicesByService(service) {
+		for _, endpoint := range endpointSlice.Endpoints {
+			targetKey := ""
+			if endpoint.TargetRef != nil {
+				namespace := endpoint.TargetRef.Namespace
+				if namespace == "" {
+					namespace = endpointSlice.Namespace
 				}
-				mergeMaps(restrictedPorts, r.getAdditionalPortsOfPod(pod))
-				for _, ingress := range service.Status.LoadBalancer.Ingress {
-					if ingress.IP != "" {
-						restrictedIps = append(restrictedIps, ingress.IP)
-					}
+				targetKey = namespace + "/" + endpoint.TargetRef.Name
+			}
+			if len(endpoint.Addresses) == 0 || !r.k8sClient.IsEndpointReady(endpoint) {
+				log.Trace().Str("service", service.Name).Str("namespace", service.Namespace).Strs("addresses", endpoint.Addresses).Msg("Exclude endpoint because it is not ready.")
+				trace.addFor(targetKey, "endpoint ready", false, endpointSlice.Name)
+				continue
+			}
+			trace.addFor(targetKey, "endpoint ready", true, endpointSlice.Name)
+
+			key := endpoint.Addresses[0]
+			var ports map[int]string
+			if endpoint.TargetRef != nil && endpoint.TargetRef.Kind == "Pod" {
+				pod := r.k8sClient.PodByEndpoint(endpointSlice, endpoint)
+				if pod == nil {
+					log.Trace().Str("service", service.Name).Str("namespace", service.Namespace).Str("pod", endpoint.TargetRef.Name).Msg("Exclude endpoint because the pod is unknown.")
+					trace.addFor(targetKey, "pod known", false, "")
+					continue
 				}
-				restrictedIps = append(restrictedIps, clusterIPsOfService(service)...)
-				if pod.Status.PodIP != "" {
-					restrictedIps = append(restrictedIps, pod.Status.PodIP)
+				if !r.matchesLabelFilters(pod, trace) {
+					continue
 				}
-				for _, annotation := range serviceAnnotations {
-					url := fmt.Sprintf("%s://%s.%s.svc.cluster.local", annotation.Protocol, service.Name, service.Namespace)
-					if annotation.Port > 0 {
-						url += ":" + strconv.Itoa(annotation.Port)
+				if podAnnotations, _ := r.getExtensionAnnotations(pod.Namespace, pod.Annotations); len(podAnnotations) > 0 {
+					log.Trace().Str("service", service.Name).Str("namespace", service.Namespace).Str("pod", pod.Name).Msg("Exclude endpoint because the pod is registered by its own annotations.")
+					trace.addFor(targetKey, "no pod annotation", false, "pod is registered by its own annotation")
+					continue
+				}
+				key = r.key(pod)
+				ports = r.getAdditionalPortsOfPod(pod)
+			} else {
+				ports = make(map[int]string)
+				for _, port := range endpointSlice.Ports {
+					if port.Port != nil {
+						ports[int(*port.Port)] = "EndpointPort"
 					}
-					url = url + annotation.Path
-					result = append(result, ExtensionConfigAO{
-						Url:             url,
-						RestrictedIps:   restrictedIps,
-						RestrictedPorts: restrictedPorts,
-					})
 				}
 			}
+
+			if existing, ok := byKey[key]; ok {
+				for _, ip := range endpoint.Addresses {
+					if !slices.Contains(existing.ips, ip) {
+						existing.ips = append(existing.ips, ip)
+					}
+				}
+				mergeMaps(existing.ports, ports)
+				continue
+			}
+			e := &serviceEndpoint{ips: slices.Clone(endpoint.Addresses), ports: ports}
+			byKey[key] = e
+			result = append(result, e)
 		}
 	}
 	return result
@@ -206,91 +553,368 @@ func clusterIPsOfService(service *corev1.Service) []string {
 	return clusterIPs
 }
 
-func (r *AutoRegistration) getExtensionAnnotations(annotations map[string]string) []ExtensionAnnotation {
+// isNamespaceEnabled reports whether the discovery is enabled in the namespace, i.e. the namespace is not opted out.
+func (r *AutoRegistration) isNamespaceEnabled(namespace string, trace *decisionTrace) bool {
+	if isNamespaceDisabled(r.k8sClient.NamespaceByName(namespace)) {
+		log.Trace().Str("namespace", namespace).Msg("Exclude candidate because the namespace is opted out.")
+		trace.add("namespace enabled", false, namespaceDisabledKey)
+		return false
+	}
+	trace.add("namespace enabled", true, namespace)
+	return true
+}
+
+// isNamespaceDisabled reports whether the namespace is opted out by either the label or the annotation.
+func isNamespaceDisabled(namespace *corev1.Namespace) bool {
+	if namespace == nil {
+		return false
+	}
+	return strings.EqualFold(namespace.Labels[namespaceDisabledKey], "true") || strings.EqualFold(namespace.Annotations[namespaceDisabledKey], "true")
+}
+
+// getExtensionAnnotations returns the enabled extensions of the annotation, merged with the defaults of the namespace.
+// Validation errors are returned together with the valid extensions.
+func (r *AutoRegistration) getExtensionAnnotations(namespace string, annotations map[string]string) ([]ExtensionAnnotation, error) {
 	if annotations == nil {
-		return []ExtensionAnnotation{}
+		return []ExtensionAnnotation{}, nil
 	}
-	if val, ok := annotations["steadybit.com/extension-auto-registration"]; ok {
-		return r.parseAnnotationJSON(val)
+	if val, ok := annotations[extensionAnnotationKey]; ok {
+		defaults, defaultsErr := r.namespaceDefaults(namespace)
+		extensions, err := parseAnnotationJSON(val, defaults)
+		return slices.DeleteFunc(extensions, func(extension ExtensionAnnotation) bool {
+			return !extension.isEnabled()
+		}), errors.Join(defaultsErr, err)
 	}
-	return []ExtensionAnnotation{}
+	return []ExtensionAnnotation{}, nil
 }
 
-func (r *AutoRegistration) parseAnnotationJSON(value string) []ExtensionAnnotation {
-	var extAnnotations ExtensionAnnotations
-	err := json.Unmarshal([]byte(value), &extAnnotations)
+// namespaceDefaults returns the extension defaults of the namespace, or nil if there are none. Invalid defaults are
+// ignored and returned as error.
+func (r *AutoRegistration) namespaceDefaults(name string) (*ExtensionAnnotation, error) {
+	namespace := r.k8sClient.NamespaceByName(name)
+	if namespace == nil {
+		return nil, nil
+	}
+	val, ok := namespace.Annotations[namespaceDefaultsKey]
+	if !ok {
+		return nil, nil
+	}
+	defaults, err := parseDefaultsJSON(val)
 	if err != nil {
-		log.Err(err).Str("value", value).Msg("Failed to parse extension annotation. Ignoring.")
-		return []ExtensionAnnotation{}
+		return nil, fmt.Errorf("invalid defaults of namespace %s: %w", name, err)
+	}
+	return defaults, nil
+}
+
+// withAnnotationPorts returns a copy of the ports extended by the additional restricted ports of the annotation.
+func withAnnotationPorts(ports map[int]string, annotation ExtensionAnnotation) map[int]string {
+	if len(annotation.RestrictedPorts) == 0 {
+		return ports
+	}
+	result := maps.Clone(ports)
+	for _, port := range annotation.RestrictedPorts {
+		if _, ok := result[port]; !ok {
+			result[port] = "Annotation"
+		}
 	}
-	return extAnnotations.Extensions
+	return result
+}
+
+// withAnnotationIps returns a copy of the ips extended by the additional restricted ips of the annotation.
+func withAnnotationIps(ips []string, annotation ExtensionAnnotation) []string {
+	if len(annotation.RestrictedIps) == 0 {
+		return ips
+	}
+	result := slices.Clone(ips)
+	for _, ip := range annotation.RestrictedIps {
+		if !slices.Contains(result, ip) {
+			result = append(result, ip)
+		}
+	}
+	return result
 }
 
 func (r *AutoRegistration) key(pod *corev1.Pod) string {
 	return pod.Namespace + "/" + pod.Name
 }
 
+func (r *AutoRegistration) serviceKey(service *corev1.Service) string {
+	return service.Namespace + "/" + service.Name
+}
+
 func (r *AutoRegistration) getAdditionalPortsOfPod(pod *corev1.Pod) map[int]string {
-	if len(pod.Spec.Containers) == 0 {
-		return map[int]string{}
+	return restrictedPortsOfPod(pod, r.defaultHealthPort)
+}
+
+// processQueue syncs the agent whenever a sync is queued, until the queue is shut down.
+func (r *AutoRegistration) processQueue(t *agentTarget) {
+	for {
+		key, shutdown := t.queue.Get()
+		if shutdown {
+			return
+		}
+		r.syncRegistrations(t)
+		t.queue.Done(key)
+	}
+}
+
+func (r *AutoRegistration) syncRegistrations(t *agentTarget) {
+	t.syncMutex.Lock()
+	defer t.syncMutex.Unlock()
+	if r.stopped.Load() {
+		return
 	}
 
-	additionalPorts := make(map[int]string)
-	for _, container := range pod.Spec.Containers {
-		for _, port := range container.Ports {
-			additionalPorts[int(port.ContainerPort)] = "ContainerPort"
+	// changes discovered from now on are synced by the next sync
+	changes := t.changes.Load()
+	changed := changes != t.syncedChanges.Load()
+	if !changed && !r.isReconcileDue(t) && !r.isDeregistrationDue(t) {
+		log.Trace().Str("agent", t.name).Msg("No changes detected.")
+		// a sync queued before the last one replaced the queued reconcile
+		r.queueNextSync(t)
+		return
+	}
+
+	start := time.Now()
+	recordEvent := r.eventRecorderFor(t)
+	var errGet, errRemove, errAdd error
+	var added, removed []ExtensionConfigAO
+	currentRegistrations, errGet := t.agent.List(r.ctx)
+	discoveredExtensions := make([]ExtensionConfigAO, 0)
+	if errGet == nil {
+		if t.lastRegistrationCount > 0 && len(currentRegistrations) == 0 {
+			log.Warn().Str("agent", t.name).Int("expected", t.lastRegistrationCount).Msg("Agent has no extension registrations anymore. The agent was probably restarted, registering extensions again.")
 		}
-		if container.LivenessProbe != nil && container.LivenessProbe.HTTPGet != nil {
-			additionalPorts[int(container.LivenessProbe.HTTPGet.Port.IntVal)] = "LivenessProbe"
+		for _, discovered := range []*sync.Map{r.discoveredExtensions, r.discoveredServiceExtensions} {
+			discovered.Range(func(key, value any) bool {
+				v := value.([]ExtensionConfigAO)
+				discoveredExtensions = append(discoveredExtensions, v...)
+				return true
+			})
+		}
+		r.updateDiscoveredMetrics()
+		discoveredExtensions = t.acceptedExtensions(discoveredExtensions)
+		for _, extension := range discoveredExtensions {
+			t.sources.Store(extension.Url, extension.source)
 		}
-		if container.ReadinessProbe != nil && container.ReadinessProbe.HTTPGet != nil {
-			additionalPorts[int(container.ReadinessProbe.HTTPGet.Port.IntVal)] = "ReadinessProbe"
+		if !changed {
+			r.logDrift(t, currentRegistrations, discoveredExtensions)
 		}
+		retainedRegistrations := r.retainedRegistrations(t, currentRegistrations, discoveredExtensions)
+		desiredRegistrations := append(slices.Clone(discoveredExtensions), retainedRegistrations...)
+		if r.agentRegistrationStrategy == config.StrategyBreakBeforeMake {
+			removed, errRemove = removeMissingRegistrations(r.ctx, t.name, t.agent, t.owned, t.failures, currentRegistrations, desiredRegistrations, recordEvent)
+			added, errAdd = addNewRegistrations(r.ctx, t.name, t.agent, t.owned, t.failures, currentRegistrations, discoveredExtensions, recordEvent)
+		} else {
+			// the agent may not tell registrations of the same url apart, an outdated registration is therefore
+			// deregistered before its update is registered. Stale urls are deregistered after the registrations.
+			outdated := outdatedRegistrations(currentRegistrations, discoveredExtensions)
+			removedOutdated, errRemoveOutdated := removeMissingRegistrations(r.ctx, t.name, t.agent, t.owned, t.failures, outdated, desiredRegistrations, recordEvent)
+			added, errAdd = addNewRegistrations(r.ctx, t.name, t.agent, t.owned, t.failures, currentRegistrations, discoveredExtensions, recordEvent)
+			removedStale, errRemoveStale := removeMissingRegistrations(r.ctx, t.name, t.agent, t.owned, t.failures, missingRegistrations(outdated, currentRegistrations), desiredRegistrations, recordEvent)
+			removed, errRemove = append(removedOutdated, removedStale...), errors.Join(errRemoveOutdated, errRemoveStale)
+		}
+		forgetObsoleteFailures(t, currentRegistrations, discoveredExtensions)
+		t.storeView(append(missingRegistrations(removed, currentRegistrations), added...), start)
+	} else {
+		t.storeViewError(errGet)
 	}
 
-	if !r.containsValue(additionalPorts, "ReadinessProbe") && !r.containsValue(additionalPorts, "LivenessProbe") {
-		additionalPorts[8081] = "Defaulted HealthPort"
+	metrics.SyncDuration.Observe(time.Since(start).Seconds())
+	retryIn, retry := t.failures.nextRetry()
+	t.lastSyncSucceeded.Store(errGet == nil && !retry)
+	metrics.AgentSyncSucceeded.WithLabelValues(t.name).Set(boolToFloat(errGet == nil && !retry))
+	if errGet != nil {
+		retryIn = t.rateLimiter.When(t.name)
+		log.Info().Str("agent", t.name).Msgf("Retry in %s", retryIn)
+		t.queue.AddAfter(t.name, retryIn)
+	} else if retry {
+		// the registrations without failures are synced, only the failed ones are retried
+		retryIn = max(retryIn, r.agentRegistrationInterval)
+		log.Info().Str("agent", t.name).Err(errors.Join(errAdd, errRemove)).Msgf("Retry failed registrations in %s", retryIn)
+		t.rateLimiter.Forget(t.name)
+		t.queue.AddAfter(t.name, retryIn)
+	} else {
+		t.syncedChanges.Store(changes)
+		t.lastSuccessfulSync.Store(time.Now().UnixNano())
+		t.lastRegistrationCount = len(discoveredExtensions)
+		if errAdd != nil || errRemove != nil {
+			log.Warn().Str("agent", t.name).Err(errors.Join(errAdd, errRemove)).Msg("Registrations synced, some registrations were rejected permanently.")
+		} else {
+			log.Debug().Str("agent", t.name).Msg("Registrations synced successfully.")
+		}
+		t.rateLimiter.Forget(t.name)
+		r.queueNextSync(t)
 	}
-	return additionalPorts
+	r.updateSyncMetrics()
 }
 
-func (r *AutoRegistration) containsValue(m map[int]string, value string) bool {
-	for _, v := range m {
-		if v == value {
-			return true
+// queueNextSync queues the next reconcile and the end of the next deregistration grace period. An earlier queued sync of
+// the agent takes precedence, it queues the next sync again.
+func (r *AutoRegistration) queueNextSync(t *agentTarget) {
+	if lastSync := t.lastSync(); r.agentRegistrationReconcileInterval > 0 && !lastSync.IsZero() {
+		t.queue.AddAfter(t.name, r.agentRegistrationReconcileInterval-time.Since(lastSync))
+	}
+	if deregistrationIn, ok := r.nextDeregistration(t); ok && deregistrationIn > 0 {
+		t.queue.AddAfter(t.name, deregistrationIn)
+	}
+}
+
+// eventRecorderFor returns the recorder for the events of the agent. With multiple agents, the events name the agent.
+func (r *AutoRegistration) eventRecorderFor(t *agentTarget) eventRecorder {
+	return func(extension ExtensionConfigAO, eventType, reason, message string) {
+		if len(r.targets) > 1 {
+			message = fmt.Sprintf("%s (agent %s)", message, t.name)
 		}
+		r.recordExtensionEvent(t.sources, extension, eventType, reason, message)
 	}
-	return false
 }
 
-func (r *AutoRegistration) syncRegistrations() {
-	if !r.isDirty.Load() {
-		time.AfterFunc(r.agentRegistrationInterval, r.syncRegistrations)
-		log.Trace().Msgf("No changes detected, waiting for %s before next check.", r.agentRegistrationInterval)
-		return
+// forgetObsoleteFailures drops the failures of extensions which are not discovered (registrations) or not registered
+// (deregistrations) anymore.
+func forgetObsoleteFailures(t *agentTarget, currentRegistrations []ExtensionConfigAO, discoveredExtensions []ExtensionConfigAO) {
+	t.failures.forget(func(failure registrationFailure) bool {
+		candidates := discoveredExtensions
+		if failure.operation == operationDeregister {
+			candidates = currentRegistrations
+		}
+		return slices.ContainsFunc(candidates, func(e ExtensionConfigAO) bool { return e.Url == failure.registration.Url })
+	})
+}
+
+// updateSyncMetrics updates the metrics summarizing all agents: the oldest successful sync, the pending changes and the
+// failed registrations.
+func (r *AutoRegistration) updateSyncMetrics() {
+	var oldestSuccessfulSync time.Time
+	allSynced := true
+	counts := map[string]int{"permanent": 0, "transient": 0}
+	for _, t := range r.targets {
+		if lastSync := t.lastSync(); lastSync.IsZero() {
+			allSynced = false
+		} else if oldestSuccessfulSync.IsZero() || lastSync.Before(oldestSuccessfulSync) {
+			oldestSuccessfulSync = lastSync
+		}
+		for _, failure := range t.failures.list() {
+			if failure.permanent {
+				counts["permanent"]++
+			} else {
+				counts["transient"]++
+			}
+		}
+	}
+	if allSynced {
+		metrics.SetLastSuccessfulSync(oldestSuccessfulSync)
+	}
+	if !r.IsDirty() {
+		metrics.SetDirty(false)
+	}
+	for kind, count := range counts {
+		metrics.RegistrationFailures.WithLabelValues(kind).Set(float64(count))
+	}
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
 	}
+	return 0
+}
 
-	var errGet, errRemove, errAdd error
-	currentRegistrations, errGet := getCurrentRegistrations(r.httpClient)
-	if errGet == nil {
-		discoveredExtensions := make([]ExtensionConfigAO, 0)
-		r.discoveredExtensions.Range(func(key, value any) bool {
-			v := value.([]ExtensionConfigAO)
-			discoveredExtensions = append(discoveredExtensions, v...)
+func (r *AutoRegistration) updateDiscoveredMetrics() {
+	counts := make(map[string]int)
+	for _, discovered := range []*sync.Map{r.discoveredExtensions, r.discoveredServiceExtensions} {
+		discovered.Range(func(key, value any) bool {
+			namespace, _, _ := strings.Cut(key.(string), "/")
+			counts[namespace] += len(value.([]ExtensionConfigAO))
 			return true
 		})
-		r.isDirty.Store(false)
-		errRemove = removeMissingRegistrations(r.httpClient, currentRegistrations, discoveredExtensions)
-		errAdd = addNewRegistrations(r.httpClient, currentRegistrations, discoveredExtensions)
 	}
+	metrics.DiscoveredExtensions.Reset()
+	for namespace, count := range counts {
+		metrics.DiscoveredExtensions.WithLabelValues(namespace).Set(float64(count))
+	}
+}
 
-	if (errGet != nil) || (errRemove != nil) || (errAdd != nil) {
-		r.isDirty.Store(true)
-		log.Info().Msgf("Retry in %s", r.agentRegistrationIntervalAfterError)
-		time.AfterFunc(r.agentRegistrationIntervalAfterError, r.syncRegistrations)
-	} else {
-		log.Debug().Msg("Registrations synced successfully.")
-		time.AfterFunc(r.agentRegistrationInterval, r.syncRegistrations)
+// isReconcileDue reports whether the registrations should be compared with the agent even though no changes were
+// discovered. This brings back registrations that got lost on the agent side, e.g. after an agent restart. Before the first
+// successful sync, the agent is synced anyway as all targets are dirty.
+func (r *AutoRegistration) isReconcileDue(t *agentTarget) bool {
+	lastSync := t.lastSync()
+	if r.agentRegistrationReconcileInterval <= 0 || lastSync.IsZero() {
+		return false
+	}
+	return time.Since(lastSync) >= r.agentRegistrationReconcileInterval
+}
+
+// retainedRegistrations returns the registrations which are not discovered anymore, but are still within the
+// deregistration grace period. A pod flapping between ready and not ready does therefore not cause remove/re-add cycles.
+func (r *AutoRegistration) retainedRegistrations(t *agentTarget, currentRegistrations []ExtensionConfigAO, discoveredExtensions []ExtensionConfigAO) []ExtensionConfigAO {
+	result := make([]ExtensionConfigAO, 0)
+	if r.agentDeregistrationGracePeriod <= 0 {
+		return result
+	}
+
+	now := time.Now()
+	missing := make(map[string]bool)
+	for _, registration := range missingRegistrations(discoveredExtensions, currentRegistrations) {
+		if !t.owned.isRemovable(registration) {
+			continue
+		}
+		if slices.ContainsFunc(discoveredExtensions, func(e ExtensionConfigAO) bool { return e.Url == registration.Url }) {
+			// the outdated registration is replaced by the discovered one
+			continue
+		}
+		missing[registration.Url] = true
+		since, ok := t.missingSince[registration.Url]
+		if !ok {
+			since = now
+			t.missingSince[registration.Url] = now
+			log.Debug().Str("agent", t.name).Str("url", registration.Url).Msgf("Extension is not discovered anymore, deregistering it in %s.", r.agentDeregistrationGracePeriod)
+		}
+		if now.Sub(since) < r.agentDeregistrationGracePeriod {
+			result = append(result, registration)
+		}
+	}
+	for url := range t.missingSince {
+		if !missing[url] {
+			delete(t.missingSince, url)
+		}
+	}
+	return result
+}
+
+// isDeregistrationDue reports whether the grace period of a not anymore discovered registration has expired.
+func (r *AutoRegistration) isDeregistrationDue(t *agentTarget) bool {
+	deregistrationIn, ok := r.nextDeregistration(t)
+	return ok && deregistrationIn <= 0
+}
+
+// nextDeregistration returns the time until the grace period of the next not anymore discovered registration expires.
+func (r *AutoRegistration) nextDeregistration(t *agentTarget) (time.Duration, bool) {
+	var next time.Duration
+	found := false
+	for _, since := range t.missingSince {
+		if remaining := r.agentDeregistrationGracePeriod - time.Since(since); !found || remaining < next {
+			next, found = remaining, true
+		}
+	}
+	return next, found
+}
+
+func (r *AutoRegistration) logDrift(t *agentTarget, currentRegistrations []ExtensionConfigAO, discoveredExtensions []ExtensionConfigAO) {
+	missing := missingRegistrations(currentRegistrations, discoveredExtensions)
+	unexpected := missingRegistrations(discoveredExtensions, currentRegistrations)
+	if len(missing) == 0 && len(unexpected) == 0 {
+		log.Trace().Str("agent", t.name).Msg("Reconciled registrations, no drift detected.")
+		return
+	}
+	for _, registration := range missing {
+		log.Info().Str("agent", t.name).Str("url", registration.Url).Msg("Drift detected: extension is not registered at the agent.")
+	}
+	for _, registration := range unexpected {
+		if t.owned.isRemovable(registration) {
+			log.Info().Str("agent", t.name).Str("url", registration.Url).Msg("Drift detected: agent has an extension registration that is not discovered.")
+		}
 	}
 }
 
@@ -0,0 +1,151 @@
+package autoregistration
+
+import (
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	operationRegister   = "register"
+	operationDeregister = "deregister"
+)
+
+// isPermanent reports whether retrying the request is pointless. Client errors are permanent, except for timeouts, rate
+// limiting and a rejected key, which may be rotated. Connection errors and server errors are transient.
+func isPermanent(err error) bool {
+	var agentError *AgentError
+	if !errors.As(err, &agentError) {
+		return false
+	}
+	return agentError.StatusCode >= 400 && agentError.StatusCode < 500 && agentError.StatusCode != http.StatusUnauthorized &&
+		agentError.StatusCode != http.StatusRequestTimeout && agentError.StatusCode != http.StatusTooManyRequests
+}
+
+// registrationFailures tracks the failed registrations and deregistrations per url. Transient failures are retried with
+// exponential backoff and jitter, permanent failures are not retried until the registration changes.
+type registrationFailures struct {
+	mu          sync.Mutex
+	interval    time.Duration
+	maxInterval time.Duration
+	entries     map[string]*registrationFailure
+	now         func() time.Time
+}
+
+type registrationFailure struct {
+	operation string
+	// registration is the failed registration, a changed registration with the same url is attempted immediately
+	registration ExtensionConfigAO
+	attempts     int
+	permanent    bool
+	retryAt      time.Time
+	err          error
+}
+
+func newRegistrationFailures(interval time.Duration, maxInterval time.Duration) *registrationFailures {
+	return &registrationFailures{
+		interval:    interval,
+		maxInterval: max(interval, maxInterval),
+		entries:     make(map[string]*registrationFailure),
+		now:         time.Now,
+	}
+}
+
+func failureKey(operation string, registration ExtensionConfigAO) string {
+	return operation + " " + registration.Url
+}
+
+// shouldAttempt reports whether the operation should be attempted for the registration now.
+func (f *registrationFailures) shouldAttempt(operation string, registration ExtensionConfigAO) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := failureKey(operation, registration)
+	failure, ok := f.entries[key]
+	if !ok {
+		return true
+	}
+	if !extensionsEqual(failure.registration, registration) {
+		delete(f.entries, key)
+		return true
+	}
+	return !failure.permanent && !f.now().Before(failure.retryAt)
+}
+
+// failed records a failed attempt and returns the failure.
+func (f *registrationFailures) failed(operation string, registration ExtensionConfigAO, err error) registrationFailure {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := failureKey(operation, registration)
+	failure, ok := f.entries[key]
+	if !ok || !extensionsEqual(failure.registration, registration) {
+		failure = &registrationFailure{operation: operation, registration: registration}
+		f.entries[key] = failure
+	}
+	failure.attempts++
+	failure.err = err
+	failure.permanent = isPermanent(err)
+	failure.retryAt = f.now().Add(f.backoff(failure.attempts))
+	return *failure
+}
+
+// backoff returns the delay before the next attempt: the interval doubled per failed attempt up to the max interval,
+// randomized between 50% and 100% to spread the retries.
+func (f *registrationFailures) backoff(attempts int) time.Duration {
+	delay := f.interval
+	for i := 1; i < attempts && delay < f.maxInterval; i++ {
+		delay *= 2
+	}
+	delay = min(delay, f.maxInterval)
+	return delay/2 + rand.N(delay/2+1)
+}
+
+func (f *registrationFailures) succeeded(operation string, registration ExtensionConfigAO) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.entries, failureKey(operation, registration))
+}
+
+// forget removes the failures which are not relevant anymore, e.g. of registrations which are not discovered anymore.
+func (f *registrationFailures) forget(keep func(failure registrationFailure) bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for key, failure := range f.entries {
+		if !keep(*failure) {
+			delete(f.entries, key)
+		}
+	}
+}
+
+// nextRetry returns the time until the next transient failure is due, or false if there is none.
+func (f *registrationFailures) nextRetry() (time.Duration, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var next time.Time
+	for _, failure := range f.entries {
+		if !failure.permanent && (next.IsZero() || failure.retryAt.Before(next)) {
+			next = failure.retryAt
+		}
+	}
+	if next.IsZero() {
+		return 0, false
+	}
+	return max(next.Sub(f.now()), 0), true
+}
+
+// list returns a copy of all failures.
+func (f *registrationFailures) list() []registrationFailure {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	result := make([]registrationFailure, 0, len(f.entries))
+	for _, failure := range f.entries {
+		result = append(result, *failure)
+	}
+	slices.SortFunc(result, func(a, b registrationFailure) int {
+		return strings.Compare(failureKey(a.operation, a.registration), failureKey(b.operation, b.registration))
+	})
+	return result
+}
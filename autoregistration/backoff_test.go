@@ -0,0 +1,77 @@
+package autoregistration
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsPermanent(t *testing.T) {
+	assert.True(t, isPermanent(&AgentError{Operation: operationRegister, StatusCode: 400, Status: "400 Bad Request"}))
+	assert.True(t, isPermanent(&AgentError{Operation: operationRegister, StatusCode: 403, Status: "403 Forbidden"}))
+	assert.False(t, isPermanent(&AgentError{Operation: operationRegister, StatusCode: 401, Status: "401 Unauthorized"}))
+	assert.False(t, isPermanent(&AgentError{Operation: operationRegister, StatusCode: 429, Status: "429 Too Many Requests"}))
+	assert.False(t, isPermanent(&AgentError{Operation: operationRegister, StatusCode: 503, Status: "503 Service Unavailable"}))
+	assert.False(t, isPermanent(errors.New("connection refused")))
+}
+
+func TestRegistrationFailures_should_back_off_transient_failures(t *testing.T) {
+	now := time.Now()
+	failures := newRegistrationFailures(time.Second, 4*time.Second)
+	failures.now = func() time.Time { return now }
+	registration := ExtensionConfigAO{Url: "http://10.0.0.1:8080"}
+	serverError := &AgentError{Operation: operationRegister, StatusCode: 500, Status: "500 Internal Server Error"}
+
+	assert.True(t, failures.shouldAttempt(operationRegister, registration))
+	for attempt, maxDelay := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 4 * time.Second} {
+		failure := failures.failed(operationRegister, registration, serverError)
+		assert.Equal(t, attempt+1, failure.attempts)
+		assert.False(t, failure.permanent)
+		delay := failure.retryAt.Sub(now)
+		assert.GreaterOrEqual(t, delay, maxDelay/2)
+		assert.LessOrEqual(t, delay, maxDelay)
+	}
+	assert.False(t, failures.shouldAttempt(operationRegister, registration))
+	assert.True(t, failures.shouldAttempt(operationDeregister, registration), "other operations are not affected")
+	retryIn, ok := failures.nextRetry()
+	assert.True(t, ok)
+	assert.LessOrEqual(t, retryIn, 4*time.Second)
+
+	now = now.Add(4 * time.Second)
+	assert.True(t, failures.shouldAttempt(operationRegister, registration))
+	failures.succeeded(operationRegister, registration)
+	_, ok = failures.nextRetry()
+	assert.False(t, ok)
+	assert.Empty(t, failures.list())
+}
+
+func TestRegistrationFailures_should_not_retry_permanent_failures_until_changed(t *testing.T) {
+	now := time.Now()
+	failures := newRegistrationFailures(time.Second, time.Minute)
+	failures.now = func() time.Time { return now }
+	registration := ExtensionConfigAO{Url: "http://10.0.0.1:8080", RestrictedIps: []string{"10.0.0.1"}}
+
+	failure := failures.failed(operationRegister, registration, &AgentError{Operation: operationRegister, StatusCode: 400, Status: "400 Bad Request"})
+	assert.True(t, failure.permanent)
+	_, ok := failures.nextRetry()
+	assert.False(t, ok)
+
+	now = now.Add(time.Hour)
+	assert.False(t, failures.shouldAttempt(operationRegister, registration))
+
+	changed := registration
+	changed.RestrictedIps = []string{"10.0.0.1", "10.0.0.2"}
+	assert.True(t, failures.shouldAttempt(operationRegister, changed))
+	assert.Empty(t, failures.list())
+}
+
+func TestRegistrationFailures_forget(t *testing.T) {
+	failures := newRegistrationFailures(time.Second, time.Minute)
+	failures.failed(operationRegister, ExtensionConfigAO{Url: "http://a"}, errors.New("connection refused"))
+	failures.failed(operationRegister, ExtensionConfigAO{Url: "http://b"}, errors.New("connection refused"))
+	failures.forget(func(failure registrationFailure) bool { return failure.registration.Url == "http://a" })
+	assert.Len(t, failures.list(), 1)
+	assert.Equal(t, "http://a", failures.list()[0].registration.Url)
+}
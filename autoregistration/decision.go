@@ -0,0 +1,109 @@
+package autoregistration
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Decision explains why a pod is or isn't registered, by its own annotation or as endpoint of an annotated service.
+type Decision struct {
+	Pod    string          `json:"pod"`
+	Checks []DecisionCheck `json:"checks"`
+	// Extensions contains the extensions registered by the annotation of the pod itself.
+	Extensions []ExtensionConfigAO `json:"extensions"`
+	Services   []ServiceDecision   `json:"services"`
+}
+
+// ServiceDecision explains the registration of a service the pod is an endpoint of.
+type ServiceDecision struct {
+	Service    string              `json:"service"`
+	Checks     []DecisionCheck     `json:"checks"`
+	Extensions []ExtensionConfigAO `json:"extensions"`
+}
+
+type DecisionCheck struct {
+	Check  string `json:"check"`
+	Passed bool   `json:"passed"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// decisionTrace collects the checks made for a single pod. All methods are no-ops on a nil trace, so the discovery code
+// can pass it along unconditionally.
+type decisionTrace struct {
+	pod    string
+	checks []DecisionCheck
+}
+
+func (t *decisionTrace) add(check string, passed bool, detail string) {
+	if t != nil {
+		t.checks = append(t.checks, DecisionCheck{Check: check, Passed: passed, Detail: detail})
+	}
+}
+
+// addFor adds the check only if it was made for the traced pod, e.g. while iterating the endpoints of a service.
+func (t *decisionTrace) addFor(pod string, check string, passed bool, detail string) {
+	if t != nil && t.pod == pod {
+		t.add(check, passed, detail)
+	}
+}
+
+func (t *decisionTrace) addAnnotation(annotations []ExtensionAnnotation, err error) {
+	if t == nil {
+		return
+	}
+	if err != nil {
+		t.add("annotation valid", false, err.Error())
+	}
+	if len(annotations) == 0 {
+		t.add("annotation", false, "no enabled extensions")
+		return
+	}
+	value, _ := json.Marshal(annotations)
+	t.add("annotation", true, string(value))
+}
+
+// Explain returns the decision for the pod with the given namespace and name, or nil if the pod is unknown.
+func (r *AutoRegistration) Explain(namespace string, name string) *Decision {
+	pod := r.k8sClient.PodByName(namespace, name)
+	if pod == nil {
+		return nil
+	}
+
+	trace := &decisionTrace{pod: r.key(pod)}
+	decision := &Decision{
+		Pod:        trace.pod,
+		Extensions: r.toExtensionConfigs(pod, trace),
+		Checks:     trace.checks,
+		Services:   []ServiceDecision{},
+	}
+	for _, service := range r.k8sClient.ServicesByPod(pod) {
+		serviceTrace := &decisionTrace{pod: trace.pod}
+		extensions := r.toServiceExtensionConfigs(service, serviceTrace)
+		decision.Services = append(decision.Services, ServiceDecision{
+			Service:    r.serviceKey(service),
+			Checks:     serviceTrace.checks,
+			Extensions: extensions,
+		})
+	}
+	return decision
+}
+
+func (r *AutoRegistration) handleExplain(w http.ResponseWriter, req *http.Request, _ []byte) {
+	namespace := req.URL.Query().Get("namespace")
+	name := req.URL.Query().Get("name")
+	if namespace == "" || name == "" {
+		http.Error(w, "query parameters namespace and name are required", http.StatusBadRequest)
+		return
+	}
+	decision := r.Explain(namespace, name)
+	if decision == nil {
+		http.Error(w, "pod not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(decision); err != nil {
+		log.Error().Err(err).Msg("Failed to write decision.")
+	}
+}
@@ -0,0 +1,65 @@
+package autoregistration
+
+import (
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// reasons of the Kubernetes events recorded on the annotated pods and services
+const (
+	reasonInvalidAnnotation    = "InvalidExtensionAnnotation"
+	reasonMissingPodIP         = "ExtensionPodWithoutIP"
+	reasonRegistered           = "ExtensionRegistered"
+	reasonDeregistered         = "ExtensionDeregistered"
+	reasonRegistrationFailed   = "ExtensionRegistrationFailed"
+	reasonDeregistrationFailed = "ExtensionDeregistrationFailed"
+)
+
+type eventRecorder func(extension ExtensionConfigAO, eventType, reason, message string)
+
+// recordExtensionEvent records an event on the pod or service the extension was discovered from. Registrations
+// returned by the agent do not carry their source, it is looked up by the url in the sources of the agent.
+func (r *AutoRegistration) recordExtensionEvent(sources *sync.Map, extension ExtensionConfigAO, eventType, reason, message string) {
+	source := extension.source
+	if source == nil {
+		if value, ok := sources.Load(extension.Url); ok {
+			source = value.(*corev1.ObjectReference)
+		}
+	}
+	if source == nil {
+		return
+	}
+	if reason == reasonDeregistered {
+		sources.Delete(extension.Url)
+	}
+	r.k8sClient.RecordEvent(source, eventType, reason, message)
+}
+
+// recordDiscoveryEvent records an event on the pod or service found by the discovery. Explaining a decision traces the
+// discovery and must not have side effects, no event is recorded then.
+func (r *AutoRegistration) recordDiscoveryEvent(trace *decisionTrace, ref *corev1.ObjectReference, eventType, reason, message string) {
+	if trace == nil {
+		r.k8sClient.RecordEvent(ref, eventType, reason, message)
+	}
+}
+
+func podReference(pod *corev1.Pod) *corev1.ObjectReference {
+	return &corev1.ObjectReference{
+		Kind:       "Pod",
+		APIVersion: "v1",
+		Namespace:  pod.Namespace,
+		Name:       pod.Name,
+		UID:        pod.UID,
+	}
+}
+
+func serviceReference(service *corev1.Service) *corev1.ObjectReference {
+	return &corev1.ObjectReference{
+		Kind:       "Service",
+		APIVersion: "v1",
+		Namespace:  service.Namespace,
+		Name:       service.Name,
+		UID:        service.UID,
+	}
+}
@@ -0,0 +1,156 @@
+package autoregistration
+
+import (
+	"encoding/json"
+	"errors"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+)
+
+// ownership keeps track of the registrations created by the auto registration. Only owned registrations are ever
+// deregistered, registrations made by hand or by other mechanisms are left untouched, even if they share the url of an
+// owned registration. The owned registrations are persisted to the state file (if configured) to survive restarts.
+type ownership struct {
+	mu            sync.RWMutex
+	stateFile     string
+	registrations map[string]ExtensionConfigAO
+	protected     []*regexp.Regexp
+}
+
+type ownershipState struct {
+	Registrations []ExtensionConfigAO `json:"registrations"`
+}
+
+func newOwnership(stateFile string, protectedUrls []string) *ownership {
+	o := &ownership{
+		stateFile:     stateFile,
+		registrations: make(map[string]ExtensionConfigAO),
+	}
+	for _, pattern := range protectedUrls {
+		if pattern != "" {
+			o.protected = append(o.protected, globToRegexp(pattern))
+		}
+	}
+	o.load()
+	return o
+}
+
+// globToRegexp converts a pattern with `*` wildcards into a regular expression. Other characters are matched literally.
+func globToRegexp(pattern string) *regexp.Regexp {
+	parts := strings.Split(pattern, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	return regexp.MustCompile("^" + strings.Join(parts, ".*") + "$")
+}
+
+// ownershipKey identifies the registration by all fields sent to the agent, independent of the order of the types and
+// restricted ips.
+func ownershipKey(registration ExtensionConfigAO) string {
+	registration.Types = slices.Sorted(slices.Values(registration.Types))
+	registration.RestrictedIps = slices.Sorted(slices.Values(registration.RestrictedIps))
+	key, _ := json.Marshal(registration)
+	return string(key)
+}
+
+func (o *ownership) isOwned(registration ExtensionConfigAO) bool {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	return o.isOwnedLocked(ownershipKey(registration))
+}
+
+// adopts reports whether registrations equal to a discovered extension are owned, although they were not created by
+// this process. Without a state file, the registrations created before a restart are otherwise never owned again. With a
+// state file, only the registrations recorded in it are owned.
+func (o *ownership) adopts() bool {
+	return o.stateFile == ""
+}
+
+func (o *ownership) isProtected(registration ExtensionConfigAO) bool {
+	return slices.ContainsFunc(o.protected, func(pattern *regexp.Regexp) bool {
+		return pattern.MatchString(registration.Url)
+	})
+}
+
+// isRemovable reports whether the registration may be deregistered by the auto registration.
+func (o *ownership) isRemovable(registration ExtensionConfigAO) bool {
+	return o.isOwned(registration) && !o.isProtected(registration)
+}
+
+func (o *ownership) own(registration ExtensionConfigAO) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if key := ownershipKey(registration); !o.isOwnedLocked(key) {
+		o.registrations[key] = registration
+		o.save()
+	}
+}
+
+func (o *ownership) release(registration ExtensionConfigAO) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if key := ownershipKey(registration); o.isOwnedLocked(key) {
+		delete(o.registrations, key)
+		o.save()
+	}
+}
+
+func (o *ownership) isOwnedLocked(key string) bool {
+	_, ok := o.registrations[key]
+	return ok
+}
+
+func (o *ownership) load() {
+	if o.stateFile == "" {
+		return
+	}
+	content, err := os.ReadFile(o.stateFile)
+	if errors.Is(err, os.ErrNotExist) {
+		log.Debug().Str("file", o.stateFile).Msg("No state file found, starting without owned registrations.")
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Str("file", o.stateFile).Msg("Failed to read state file, starting without owned registrations.")
+		return
+	}
+	var state ownershipState
+	if err := json.Unmarshal(content, &state); err != nil {
+		log.Error().Err(err).Str("file", o.stateFile).Msg("Failed to parse state file, starting without owned registrations.")
+		return
+	}
+	for _, registration := range state.Registrations {
+		o.registrations[ownershipKey(registration)] = registration
+	}
+	log.Info().Str("file", o.stateFile).Int("count", len(state.Registrations)).Msg("Loaded owned registrations from state file.")
+}
+
+func (o *ownership) save() {
+	if o.stateFile == "" {
+		return
+	}
+	state := ownershipState{Registrations: make([]ExtensionConfigAO, 0, len(o.registrations))}
+	for _, key := range slices.Sorted(maps.Keys(o.registrations)) {
+		state.Registrations = append(state.Registrations, o.registrations[key])
+	}
+	content, err := json.Marshal(state)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to serialize owned registrations.")
+		return
+	}
+	// write to a temporary file first, a crash must never leave a truncated state file behind
+	tmpFile := filepath.Join(filepath.Dir(o.stateFile), "."+filepath.Base(o.stateFile)+".tmp")
+	if err := os.WriteFile(tmpFile, content, 0o600); err != nil {
+		log.Error().Err(err).Str("file", tmpFile).Msg("Failed to write state file.")
+		return
+	}
+	if err := os.Rename(tmpFile, o.stateFile); err != nil {
+		log.Error().Err(err).Str("file", o.stateFile).Msg("Failed to write state file.")
+	}
+}
@@ -0,0 +1,55 @@
+package autoregistration
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOwnership_should_persist_owned_registrations(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+	registration := ExtensionConfigAO{Url: "http://192.168.1.1:8080"}
+
+	owned := newOwnership(stateFile, nil)
+	assert.False(t, owned.isOwned(registration))
+	owned.own(registration)
+	assert.True(t, owned.isOwned(registration))
+
+	restored := newOwnership(stateFile, nil)
+	assert.True(t, restored.isOwned(registration))
+	restored.release(registration)
+
+	assert.False(t, newOwnership(stateFile, nil).isOwned(registration))
+}
+
+func TestOwnership_should_only_own_the_exact_registration(t *testing.T) {
+	owned := newOwnership("", nil)
+	owned.own(ExtensionConfigAO{Url: "http://192.168.1.1:8080", Types: []string{"host", "container"}, RestrictedPorts: map[int]string{8080: "ContainerPort"}})
+
+	assert.True(t, owned.isOwned(ExtensionConfigAO{Url: "http://192.168.1.1:8080", Types: []string{"container", "host"}, RestrictedPorts: map[int]string{8080: "ContainerPort"}}))
+	assert.False(t, owned.isOwned(ExtensionConfigAO{Url: "http://192.168.1.1:8080", Types: []string{"host", "container"}}), "a registration sharing the url should not be owned")
+	assert.False(t, owned.isOwned(ExtensionConfigAO{Url: "http://192.168.1.1:8080"}), "a registration sharing the url should not be owned")
+}
+
+func TestOwnership_isRemovable(t *testing.T) {
+	owned := newOwnership("", []string{"http://extension-manual.*", "http://10.0.0.1:8080"})
+	for _, url := range []string{"http://extension-manual.default.svc.cluster.local:8080", "http://10.0.0.1:8080", "http://192.168.1.1:8080"} {
+		owned.own(ExtensionConfigAO{Url: url})
+	}
+
+	tests := []struct {
+		url      string
+		expected bool
+	}{
+		{url: "http://extension-manual.default.svc.cluster.local:8080", expected: false},
+		{url: "http://10.0.0.1:8080", expected: false},
+		{url: "http://10.0.0.1:8081", expected: false},
+		{url: "http://192.168.1.1:8080", expected: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			assert.Equal(t, tt.expected, owned.isRemovable(ExtensionConfigAO{Url: tt.url}))
+		})
+	}
+}
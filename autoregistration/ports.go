@@ -0,0 +1,120 @@
+package autoregistration
+
+import (
+	"maps"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// restrictedPortsOfPod returns the container ports and the probe ports of the running containers of the pod, including
+// native sidecar containers. Named probe ports are resolved against the ports of the same container. Each entry names the
+// container it came from. If the pod has no probe ports, the default health port is added (0 disables it).
+func restrictedPortsOfPod(pod *corev1.Pod, defaultHealthPort int) map[int]string {
+	ports := make(map[int]string)
+	containers := runningContainers(pod)
+	if len(containers) == 0 {
+		return ports
+	}
+
+	hasProbePort := false
+	// sidecar containers come first, the ports of the regular containers take precedence
+	for _, container := range containers {
+		for _, port := range container.Ports {
+			ports[int(port.ContainerPort)] = portDescription("ContainerPort", container)
+			if port.HostPort > 0 && port.HostPort != port.ContainerPort {
+				ports[int(port.HostPort)] = portDescription("HostPort", container)
+			}
+		}
+		for _, probe := range []struct {
+			probe *corev1.Probe
+			name  string
+		}{
+			{container.LivenessProbe, "LivenessProbe"},
+			{container.ReadinessProbe, "ReadinessProbe"},
+			{container.StartupProbe, "StartupProbe"},
+		} {
+			if port := probePort(container, probe.probe); port > 0 {
+				ports[port] = portDescription(probe.name, container)
+				hasProbePort = true
+			}
+		}
+	}
+
+	if !hasProbePort && defaultHealthPort > 0 {
+		if _, ok := ports[defaultHealthPort]; !ok {
+			ports[defaultHealthPort] = "Defaulted HealthPort"
+		}
+	}
+	return ports
+}
+
+func portDescription(kind string, container corev1.Container) string {
+	if container.Name == "" {
+		return kind
+	}
+	return kind + " (" + container.Name + ")"
+}
+
+// runningContainers returns the native sidecar containers, i.e. the init containers restarted always, and the regular
+// containers of the pod. Other init containers have terminated before the regular containers start.
+func runningContainers(pod *corev1.Pod) []corev1.Container {
+	result := make([]corev1.Container, 0, len(pod.Spec.InitContainers)+len(pod.Spec.Containers))
+	for _, container := range pod.Spec.InitContainers {
+		if container.RestartPolicy != nil && *container.RestartPolicy == corev1.ContainerRestartPolicyAlways {
+			result = append(result, container)
+		}
+	}
+	return append(result, pod.Spec.Containers...)
+}
+
+// hostPortOf returns the host port mapped to the container port, or 0 if the port is not exposed on the node.
+func hostPortOf(pod *corev1.Pod, containerPort int) int {
+	for _, container := range runningContainers(pod) {
+		for _, port := range container.Ports {
+			if int(port.ContainerPort) == containerPort && port.HostPort > 0 {
+				return int(port.HostPort)
+			}
+		}
+	}
+	return 0
+}
+
+// probePort returns the port checked by the probe, or 0 if the probe has no (resolvable) port.
+func probePort(container corev1.Container, probe *corev1.Probe) int {
+	if probe == nil {
+		return 0
+	}
+	switch {
+	case probe.HTTPGet != nil:
+		return resolvePort(container, probe.HTTPGet.Port)
+	case probe.TCPSocket != nil:
+		return resolvePort(container, probe.TCPSocket.Port)
+	case probe.GRPC != nil:
+		return int(probe.GRPC.Port)
+	}
+	return 0
+}
+
+// resolvePort resolves a numeric or named port against the ports of the container.
+func resolvePort(container corev1.Container, port intstr.IntOrString) int {
+	if port.Type == intstr.Int {
+		return int(port.IntVal)
+	}
+	for _, containerPort := range container.Ports {
+		if containerPort.Name == port.StrVal {
+			return int(containerPort.ContainerPort)
+		}
+	}
+	return 0
+}
+
+// withPort returns a copy of the ports extended by the port, unless the port is already contained.
+func withPort(ports map[int]string, port int, value string) map[int]string {
+	if _, ok := ports[port]; ok || port <= 0 {
+		return ports
+	}
+	result := maps.Clone(ports)
+	result[port] = value
+	return result
+}
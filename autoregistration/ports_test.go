@@ -0,0 +1,118 @@
+package autoregistration
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func TestRestrictedPortsOfPod(t *testing.T) {
+	tests := []struct {
+		name              string
+		initContainers    []corev1.Container
+		containers        []corev1.Container
+		defaultHealthPort int
+		expected          map[int]string
+	}{
+		{
+			name: "named http probe port",
+			containers: []corev1.Container{{
+				Ports:          []corev1.ContainerPort{{Name: "http", ContainerPort: 8080}, {Name: "health", ContainerPort: 8081}},
+				LivenessProbe:  &corev1.Probe{ProbeHandler: corev1.ProbeHandler{HTTPGet: &corev1.HTTPGetAction{Port: intstr.FromString("health")}}},
+				ReadinessProbe: &corev1.Probe{ProbeHandler: corev1.ProbeHandler{HTTPGet: &corev1.HTTPGetAction{Port: intstr.FromInt32(8082)}}},
+			}},
+			defaultHealthPort: 8081,
+			expected:          map[int]string{8080: "ContainerPort", 8081: "LivenessProbe", 8082: "ReadinessProbe"},
+		},
+		{
+			name: "startup, tcp and grpc probes",
+			containers: []corev1.Container{{
+				Ports:          []corev1.ContainerPort{{Name: "tcp", ContainerPort: 9000}},
+				LivenessProbe:  &corev1.Probe{ProbeHandler: corev1.ProbeHandler{GRPC: &corev1.GRPCAction{Port: 9001}}},
+				ReadinessProbe: &corev1.Probe{ProbeHandler: corev1.ProbeHandler{TCPSocket: &corev1.TCPSocketAction{Port: intstr.FromString("tcp")}}},
+				StartupProbe:   &corev1.Probe{ProbeHandler: corev1.ProbeHandler{TCPSocket: &corev1.TCPSocketAction{Port: intstr.FromInt32(9002)}}},
+			}},
+			defaultHealthPort: 8081,
+			expected:          map[int]string{9000: "ReadinessProbe", 9001: "LivenessProbe", 9002: "StartupProbe"},
+		},
+		{
+			name: "unresolvable named port falls back to the default health port",
+			containers: []corev1.Container{{
+				Ports:         []corev1.ContainerPort{{ContainerPort: 8080}},
+				LivenessProbe: &corev1.Probe{ProbeHandler: corev1.ProbeHandler{HTTPGet: &corev1.HTTPGetAction{Port: intstr.FromString("unknown")}}},
+			}},
+			defaultHealthPort: 8081,
+			expected:          map[int]string{8080: "ContainerPort", 8081: "Defaulted HealthPort"},
+		},
+		{
+			name: "exec probe and disabled default health port",
+			containers: []corev1.Container{{
+				Ports:         []corev1.ContainerPort{{ContainerPort: 8080}},
+				LivenessProbe: &corev1.Probe{ProbeHandler: corev1.ProbeHandler{Exec: &corev1.ExecAction{Command: []string{"true"}}}},
+			}},
+			defaultHealthPort: 0,
+			expected:          map[int]string{8080: "ContainerPort"},
+		},
+		{
+			name: "native sidecar and regular init containers",
+			initContainers: []corev1.Container{
+				{Name: "init", Ports: []corev1.ContainerPort{{ContainerPort: 7000}}},
+				{Name: "init-on-failure", RestartPolicy: new(corev1.ContainerRestartPolicyOnFailure), Ports: []corev1.ContainerPort{{ContainerPort: 7001}}},
+				{
+					Name:          "sidecar",
+					RestartPolicy: new(corev1.ContainerRestartPolicyAlways),
+					Ports:         []corev1.ContainerPort{{Name: "metrics", ContainerPort: 9090}},
+					LivenessProbe: &corev1.Probe{ProbeHandler: corev1.ProbeHandler{HTTPGet: &corev1.HTTPGetAction{Port: intstr.FromString("metrics")}}},
+				},
+			},
+			containers: []corev1.Container{{
+				Name:  "main",
+				Ports: []corev1.ContainerPort{{ContainerPort: 8080}},
+			}},
+			defaultHealthPort: 8081,
+			expected:          map[int]string{8080: "ContainerPort (main)", 9090: "LivenessProbe (sidecar)"},
+		},
+		{
+			name: "host port",
+			containers: []corev1.Container{{
+				Name:  "main",
+				Ports: []corev1.ContainerPort{{ContainerPort: 8080, HostPort: 18080}, {ContainerPort: 8081, HostPort: 8081}},
+			}},
+			defaultHealthPort: 0,
+			expected:          map[int]string{8080: "ContainerPort (main)", 8081: "ContainerPort (main)", 18080: "HostPort (main)"},
+		},
+		{
+			name: "custom default health port",
+			containers: []corev1.Container{{
+				Ports: []corev1.ContainerPort{{ContainerPort: 8080}},
+			}},
+			defaultHealthPort: 9090,
+			expected:          map[int]string{8080: "ContainerPort", 9090: "Defaulted HealthPort"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := &corev1.Pod{Spec: corev1.PodSpec{InitContainers: tt.initContainers, Containers: tt.containers}}
+			assert.Equal(t, tt.expected, restrictedPortsOfPod(pod, tt.defaultHealthPort))
+		})
+	}
+}
+
+func TestHostPortOf(t *testing.T) {
+	pod := &corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{
+		Ports: []corev1.ContainerPort{{ContainerPort: 8080, HostPort: 18080}, {ContainerPort: 8081}},
+	}}}}
+	assert.Equal(t, 18080, hostPortOf(pod, 8080))
+	assert.Equal(t, 0, hostPortOf(pod, 8081))
+	assert.Equal(t, 0, hostPortOf(pod, 9090))
+}
+
+func TestWithPort(t *testing.T) {
+	ports := map[int]string{8080: "ContainerPort"}
+	assert.Equal(t, map[int]string{8080: "ContainerPort", 8085: "AnnotationPort"}, withPort(ports, 8085, "AnnotationPort"))
+	assert.Equal(t, ports, withPort(ports, 8080, "AnnotationPort"))
+	assert.Equal(t, ports, withPort(ports, 0, "AnnotationPort"))
+	assert.Equal(t, map[int]string{8080: "ContainerPort"}, ports, "the ports must not be modified")
+}
@@ -0,0 +1,153 @@
+package autoregistration
+
+import (
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/steadybit/extension-kit/exthttp"
+)
+
+// RegistrationsStatus describes the discovered extensions, the registrations known by the agent and the pending changes.
+// The agent fields describe the first agent, Agents lists all agents if more than one agent is configured.
+type RegistrationsStatus struct {
+	// Pods contains the extensions discovered by pod annotations, grouped by the pod key (namespace/name).
+	Pods map[string][]ExtensionConfigAO `json:"pods"`
+	// Services contains the extensions discovered by service annotations, grouped by the service key (namespace/name).
+	Services map[string][]ExtensionConfigAO `json:"services"`
+	AgentRegistrationsStatus
+	Agents []AgentRegistrationsStatus `json:"agents,omitempty"`
+}
+
+// AgentRegistrationsStatus describes the registrations known by an agent as of the last sync, the pending changes and
+// the failed registrations of the agent.
+type AgentRegistrationsStatus struct {
+	Name  string              `json:"name"`
+	Agent []ExtensionConfigAO `json:"agent"`
+	// SyncedAt is the time of the sync the agent registrations are from, unset if there was none yet.
+	SyncedAt   *time.Time                  `json:"syncedAt,omitempty"`
+	AgentError string                      `json:"agentError,omitempty"`
+	Pending    PendingRegistrations        `json:"pending"`
+	Failures   []RegistrationFailureStatus `json:"failures"`
+}
+
+// RegistrationFailureStatus describes a failed registration or deregistration. Permanent failures are not retried until
+// the registration changes.
+type RegistrationFailureStatus struct {
+	Operation string     `json:"operation"`
+	Url       string     `json:"url"`
+	Attempts  int        `json:"attempts"`
+	Permanent bool       `json:"permanent"`
+	Error     string     `json:"error"`
+	RetryAt   *time.Time `json:"retryAt,omitempty"`
+}
+
+type PendingRegistrations struct {
+	Add    []ExtensionConfigAO `json:"add"`
+	Remove []ExtensionConfigAO `json:"remove"`
+}
+
+// RegisterStatusHandlers registers the health, readiness, registration status and decision endpoints.
+func (r *AutoRegistration) RegisterStatusHandlers() {
+	exthttp.RegisterHttpHandler("/healthz", func(w http.ResponseWriter, _ *http.Request, _ []byte) {
+		w.WriteHeader(http.StatusOK)
+	})
+	exthttp.RegisterHttpHandler("/readyz", func(w http.ResponseWriter, _ *http.Request, _ []byte) {
+		if r.IsReady() {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+	})
+	exthttp.RegisterHttpHandler("/registrations", exthttp.GetterAsHandler(r.Registrations))
+	exthttp.RegisterHttpHandler("/explain", r.handleExplain)
+}
+
+// IsReady reports whether the Kubernetes caches are synced and the last syncs with all agents succeeded.
+func (r *AutoRegistration) IsReady() bool {
+	return r.k8sClient.HasSynced() && !slices.ContainsFunc(r.targets, func(t *agentTarget) bool { return !t.lastSyncSucceeded.Load() })
+}
+
+// Registrations returns the registration status. The agent registrations are taken from the last sync, the agents are
+// not requested.
+func (r *AutoRegistration) Registrations() RegistrationsStatus {
+	status := RegistrationsStatus{
+		Pods:     groupedExtensions(r.discoveredExtensions),
+		Services: groupedExtensions(r.discoveredServiceExtensions),
+	}
+	discoveredExtensions := make([]ExtensionConfigAO, 0)
+	for _, extensions := range status.Pods {
+		discoveredExtensions = append(discoveredExtensions, extensions...)
+	}
+	for _, extensions := range status.Services {
+		discoveredExtensions = append(discoveredExtensions, extensions...)
+	}
+
+	agents := make([]AgentRegistrationsStatus, len(r.targets))
+	for i, t := range r.targets {
+		agents[i] = agentRegistrations(t, t.acceptedExtensions(discoveredExtensions))
+	}
+	if len(agents) > 0 {
+		status.AgentRegistrationsStatus = agents[0]
+	}
+	if len(agents) > 1 {
+		status.Agents = agents
+	}
+	return status
+}
+
+func agentRegistrations(t *agentTarget, discoveredExtensions []ExtensionConfigAO) AgentRegistrationsStatus {
+	status := AgentRegistrationsStatus{
+		Name: t.name,
+		Pending: PendingRegistrations{
+			Add:    []ExtensionConfigAO{},
+			Remove: []ExtensionConfigAO{},
+		},
+		Failures: []RegistrationFailureStatus{},
+	}
+	for _, failure := range t.failures.list() {
+		failureStatus := RegistrationFailureStatus{
+			Operation: failure.operation,
+			Url:       failure.registration.Url,
+			Attempts:  failure.attempts,
+			Permanent: failure.permanent,
+			Error:     failure.err.Error(),
+		}
+		if !failure.permanent {
+			failureStatus.RetryAt = &failure.retryAt
+		}
+		status.Failures = append(status.Failures, failureStatus)
+	}
+
+	view := t.view.Load()
+	if view == nil {
+		return status
+	}
+	if view.err != nil {
+		status.AgentError = view.err.Error()
+	}
+	if view.syncedAt.IsZero() {
+		return status
+	}
+	currentRegistrations := view.registrations
+	status.Agent = currentRegistrations
+	status.SyncedAt = &view.syncedAt
+
+	status.Pending.Add = missingRegistrations(currentRegistrations, discoveredExtensions)
+	for _, registration := range missingRegistrations(discoveredExtensions, currentRegistrations) {
+		if t.owned.isRemovable(registration) {
+			status.Pending.Remove = append(status.Pending.Remove, registration)
+		}
+	}
+	return status
+}
+
+func groupedExtensions(discovered *sync.Map) map[string][]ExtensionConfigAO {
+	result := make(map[string][]ExtensionConfigAO)
+	discovered.Range(func(key, value any) bool {
+		result[key.(string)] = value.([]ExtensionConfigAO)
+		return true
+	})
+	return result
+}
@@ -1,18 +1,37 @@
 package autoregistration
 
+import corev1 "k8s.io/api/core/v1"
+
 type ExtensionConfigAO struct {
 	UnixSocket      string         `json:"unixSocket,omitempty"` //important even if not used to be able to delete existing registrations
 	Url             string         `json:"url,omitempty"`
 	Types           []string       `json:"types,omitempty"`
 	RestrictedPorts map[int]string `json:"restrictedPorts,omitempty"`
 	RestrictedIps   []string       `json:"restrictedIps,omitempty"`
+	// Name is the name given in the annotation. It is only used for logging and is not sent to the agent.
+	Name string `json:"-"`
+	// source is the pod or service the extension was discovered from
+	source *corev1.ObjectReference
+	// sourceLabels are the labels of the source, used to select the agents the extension is registered at
+	sourceLabels map[string]string
 }
 
 type ExtensionAnnotations struct {
+	Version    int                   `json:"version,omitempty"`
 	Extensions []ExtensionAnnotation `json:"extensions,omitempty"`
 }
 type ExtensionAnnotation struct {
 	Protocol string `json:"protocol,omitempty"`
 	Port     int    `json:"port,omitempty"`
 	Path     string `json:"path,omitempty"`
+	// the following fields require version 2 of the annotation schema
+	Name            string   `json:"name,omitempty"`
+	Types           []string `json:"types,omitempty"`
+	Enabled         *bool    `json:"enabled,omitempty"`
+	RestrictedPorts []int    `json:"restrictedPorts,omitempty"`
+	RestrictedIps   []string `json:"restrictedIps,omitempty"`
+}
+
+func (a ExtensionAnnotation) isEnabled() bool {
+	return a.Enabled == nil || *a.Enabled
 }
@@ -4,44 +4,133 @@
 package client
 
 import (
+	"context"
 	"errors"
 	"flag"
 	"path/filepath"
+	"slices"
+	"sync"
 	"time"
 
 	"github.com/rs/zerolog/log"
 	extconfig "github.com/steadybit/extension-auto-registration-kubernetes/config"
+	"github.com/steadybit/extension-auto-registration-kubernetes/metrics"
 	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/util/runtime"
-	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	listerCorev1 "k8s.io/client-go/listers/core/v1"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/homedir"
 )
 
 type Client struct {
-	pod struct {
-		lister   listerCorev1.PodLister
-		informer cache.SharedIndexInformer
+	clientset kubernetes.Interface
+	stopCh    <-chan struct{}
+
+	mu sync.RWMutex
+	// namespaces contains the informers per watched namespace. A cluster-wide watch uses the key "" (metav1.NamespaceAll).
+	namespaces map[string]*namespaceInformers
+	// exclude contains the namespaces ignored by a cluster-wide watch or a namespace selector
+	exclude []string
+	// namespace contains the namespace informer. It selects the watched namespaces if a namespace selector is
+	// configured and provides the namespace-level settings of the extension annotations.
+	namespace struct {
+		informer     cache.SharedIndexInformer
+		lister       listerCorev1.NamespaceLister
+		registration cache.ResourceEventHandlerRegistration
+		selector     labels.Selector
 	}
-	service struct {
-		lister   listerCorev1.ServiceLister
-		informer cache.SharedIndexInformer
+	// watchNamespaceObjects enables the namespace informer without a namespace selector
+	watchNamespaceObjects bool
+	// checkNamespacePermissions enables the permission check of namespaces added by the namespace selector
+	checkNamespacePermissions bool
+	// serviceDiscovery enables the endpoint slice informers and therefore the service-level registrations
+	serviceDiscovery bool
+	handlers         struct {
+		pod           []podHandler
+		servicePod    []podHandler
+		service       []serviceHandler
+		endpointSlice []endpointSliceHandler
+		namespace     []namespaceHandler
 	}
+
+	events struct {
+		recorder record.EventRecorder
+		mu       sync.Mutex
+		recent   map[string]time.Time
+	}
+}
+
+type podHandler struct {
+	add    func(pod *corev1.Pod)
+	update func(old *corev1.Pod, new *corev1.Pod)
+	delete func(pod *corev1.Pod)
+}
+
+type serviceHandler struct {
+	add    func(service *corev1.Service)
+	update func(old *corev1.Service, new *corev1.Service)
+	delete func(service *corev1.Service)
+}
+
+type endpointSliceHandler struct {
+	add    func(endpointSlice *discoveryv1.EndpointSlice)
+	update func(old *discoveryv1.EndpointSlice, new *discoveryv1.EndpointSlice)
+	delete func(endpointSlice *discoveryv1.EndpointSlice)
 }
 
+type namespaceHandler struct {
+	update func(old *corev1.Namespace, new *corev1.Namespace)
+}
+
+const (
+	endpointSliceByServiceIndex = "service"
+	endpointSliceByPodIndex     = "pod"
+	// podRequestTimeout limits the request of a pod not contained in the informer caches
+	podRequestTimeout = 10 * time.Second
+)
+
 func PrepareClient(stopCh <-chan struct{}) *Client {
 	clientset := createClientset()
-	result := checkPermissions(clientset)
+	var result *PermissionCheckResult
+	if namespaceSelector().Empty() {
+		for _, namespace := range watchedNamespaces() {
+			result = result.merge(checkPermissions(clientset, namespace))
+		}
+		// without a namespace selector, the namespaces are only needed for the namespace-level settings
+		result = result.merge(checkPermissionList(clientset, metav1.NamespaceAll, []requiredPermission{optionalNamespacesPermission}))
+	} else {
+		// the permissions of the selected namespaces are checked once they are added
+		result = checkPermissionList(clientset, metav1.NamespaceAll, append([]requiredPermission{namespacesPermission}, optionalPermissions()...))
+	}
 	if result.HasErrors() {
 		log.Fatal().Msg("Required permissions are missing. Exit now.")
 	}
 
-	return CreateClient(clientset, stopCh)
+	client := newClient(clientset, stopCh)
+	client.checkNamespacePermissions = true
+	client.watchNamespaceObjects = result.IsGranted(namespacesPermission)
+	if !client.watchNamespaceObjects {
+		log.Warn().Msg("Permission to watch namespaces is missing. Namespace-level settings are ignored.")
+	}
+	client.serviceDiscovery = result.IsGranted(endpointSlicesPermission)
+	if !client.serviceDiscovery {
+		log.Warn().Msg("Permission to watch endpoint slices is missing. Service-level registrations are disabled.")
+	}
+	client.start()
+	if extconfig.Config.KubernetesEvents && result.IsGranted(eventsPermission) {
+		client.StartEventRecorder(clientset, stopCh)
+	} else if extconfig.Config.KubernetesEvents {
+		log.Warn().Msg("Permission to create events is missing. Kubernetes events are disabled.")
+	}
+	return client
 }
 
 func createClientset() *kubernetes.Clientset {
@@ -84,134 +173,394 @@ func createClientset() *kubernetes.Clientset {
 
 // CreateClient is visible for testing
 func CreateClient(clientset kubernetes.Interface, stopCh <-chan struct{}) *Client {
-	client := &Client{}
+	client := newClient(clientset, stopCh)
+	client.watchNamespaceObjects = true
+	client.serviceDiscovery = true
+	client.start()
+	return client
+}
+
+func newClient(clientset kubernetes.Interface, stopCh <-chan struct{}) *Client {
+	client := &Client{
+		clientset:  clientset,
+		stopCh:     stopCh,
+		namespaces: make(map[string]*namespaceInformers),
+		exclude:    extconfig.Config.NamespacesExclude,
+	}
+	client.namespace.selector = namespaceSelector()
+	return client
+}
 
-	var factory informers.SharedInformerFactory
-	if extconfig.Config.NamespaceFilter != "" {
-		factory = informers.NewSharedInformerFactoryWithOptions(clientset, 0, informers.WithNamespace(extconfig.Config.NamespaceFilter))
+func (c *Client) start() {
+	defer runtime.HandleCrash()
+	if c.namespace.selector.Empty() {
+		for _, namespace := range watchedNamespaces() {
+			c.addNamespace(namespace)
+		}
+		if c.watchNamespaceObjects {
+			c.watchNamespaces()
+		}
 	} else {
-		factory = informers.NewSharedInformerFactory(clientset, 0)
+		c.watchNamespaces()
 	}
 
-	var informerSyncList []cache.InformerSynced
+	log.Info().Msgf("Start Kubernetes cache sync.")
+	if !cache.WaitForCacheSync(c.stopCh, c.HasSynced) {
+		log.Fatal().Msg("Timed out waiting for caches to sync")
+	}
+	log.Info().Msgf("Kubernetes caches synced.")
+}
 
-	pods := factory.Core().V1().Pods()
-	client.pod.informer = pods.Informer()
-	client.pod.lister = pods.Lister()
-	informerSyncList = append(informerSyncList, client.pod.informer.HasSynced)
-	if err := client.pod.informer.SetTransform(transformPod); err != nil {
-		log.Fatal().Err(err).Msg("Failed to add pod transformer")
+func countEvents(informer cache.SharedIndexInformer, resource string) {
+	if _, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(any) {
+			metrics.InformerEvents.WithLabelValues(resource, "added").Inc()
+		},
+		UpdateFunc: func(any, any) {
+			metrics.InformerEvents.WithLabelValues(resource, "updated").Inc()
+		},
+		DeleteFunc: func(any) {
+			metrics.InformerEvents.WithLabelValues(resource, "deleted").Inc()
+		},
+	}); err != nil {
+		log.Fatal().Msgf("failed to add %s event handler", resource)
 	}
+}
 
-	services := factory.Core().V1().Services()
-	client.service.informer = services.Informer()
-	client.service.lister = services.Lister()
-	informerSyncList = append(informerSyncList, client.service.informer.HasSynced)
-	if err := client.service.informer.SetTransform(transformService); err != nil {
-		log.Fatal().Err(err).Msg("Failed to add service transformer")
+// HasSynced reports whether all informer caches are synced.
+func (c *Client) HasSynced() bool {
+	if c.namespace.registration != nil && !c.namespace.registration.HasSynced() {
+		return false
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, informers := range c.namespaces {
+		if !informers.hasSynced() {
+			return false
+		}
 	}
+	return true
+}
 
-	defer runtime.HandleCrash()
-	go factory.Start(stopCh)
+// ServiceDiscoveryEnabled reports whether the endpoint slices are watched, which the service-level registrations are
+// based on.
+func (c *Client) ServiceDiscoveryEnabled() bool {
+	return c.serviceDiscovery
+}
 
-	log.Info().Msgf("Start Kubernetes cache sync.")
-	if !cache.WaitForCacheSync(stopCh, informerSyncList...) {
-		log.Fatal().Msg("Timed out waiting for caches to sync")
+// HandlersSynced reports whether the registered handlers were notified about all objects of the synced informer caches.
+func (c *Client) HandlersSynced() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, informers := range c.namespaces {
+		for _, registration := range informers.registrations {
+			if !registration.HasSynced() {
+				return false
+			}
+		}
 	}
-	log.Info().Msgf("Kubernetes caches synced.")
-
-	return client
+	return true
 }
 
+// WatchPods notifies about the pods relevant for pod-level registrations.
 func (c *Client) WatchPods(add func(pod *corev1.Pod), update func(old *corev1.Pod, new *corev1.Pod), delete func(pod *corev1.Pod)) {
-	if _, err := c.pod.informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	handler := podHandler{add: add, update: update, delete: delete}
+	c.handlers.pod = append(c.handlers.pod, handler)
+	for _, informers := range c.namespaces {
+		informers.registrations = append(informers.registrations, c.watchPods(informers.pod.informer, handler))
+	}
+}
+
+// WatchServicePods notifies about all pods which might back a service.
+func (c *Client) WatchServicePods(add func(pod *corev1.Pod), update func(old *corev1.Pod, new *corev1.Pod), delete func(pod *corev1.Pod)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	handler := podHandler{add: add, update: update, delete: delete}
+	c.handlers.servicePod = append(c.handlers.servicePod, handler)
+	for _, informers := range c.namespaces {
+		informers.registrations = append(informers.registrations, c.watchPods(informers.servicePod.informer, handler))
+	}
+}
+
+func (c *Client) watchPods(informer cache.SharedIndexInformer, handler podHandler) cache.ResourceEventHandlerRegistration {
+	registration, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
 		AddFunc: func(obj any) {
 			pod := obj.(*corev1.Pod)
+			if c.isExcluded(pod.Namespace) {
+				return
+			}
 			log.Trace().Str("pod", pod.Name).Str("namespace", pod.Namespace).Msg("k8s pod added")
-			add(pod)
+			handler.add(pod)
 		},
 		UpdateFunc: func(oldObj, newObj any) {
 			oldPod := oldObj.(*corev1.Pod)
 			newPod := newObj.(*corev1.Pod)
+			if c.isExcluded(newPod.Namespace) {
+				return
+			}
 			log.Trace().Str("pod", newPod.Name).Str("namespace", newPod.Namespace).Msg("k8s pod updated")
-			update(oldPod, newPod)
+			handler.update(oldPod, newPod)
 		},
 		DeleteFunc: func(obj any) {
 			pod := obj.(*corev1.Pod)
+			if c.isExcluded(pod.Namespace) {
+				return
+			}
 			log.Trace().Str("pod", pod.Name).Str("namespace", pod.Namespace).Msg("k8s pod deleted")
-			delete(pod)
+			handler.delete(pod)
 		},
-	}); err != nil {
+	})
+	if err != nil {
 		log.Fatal().Msg("failed to add pod event handler")
 	}
+	return registration
 }
 
 func (c *Client) WatchServices(add func(service *corev1.Service), update func(old *corev1.Service, new *corev1.Service), delete func(service *corev1.Service)) {
-	if _, err := c.service.informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	handler := serviceHandler{add: add, update: update, delete: delete}
+	c.handlers.service = append(c.handlers.service, handler)
+	for _, informers := range c.namespaces {
+		informers.registrations = append(informers.registrations, c.watchServices(informers.service.informer, handler))
+	}
+}
+
+func (c *Client) watchServices(informer cache.SharedIndexInformer, handler serviceHandler) cache.ResourceEventHandlerRegistration {
+	registration, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
 		AddFunc: func(obj any) {
 			service := obj.(*corev1.Service)
+			if c.isExcluded(service.Namespace) {
+				return
+			}
 			log.Trace().Str("service", service.Name).Str("namespace", service.Namespace).Msg("k8s service added")
-			add(service)
+			handler.add(service)
 		},
 		UpdateFunc: func(oldObj, newObj any) {
 			oldService := oldObj.(*corev1.Service)
 			newService := newObj.(*corev1.Service)
+			if c.isExcluded(newService.Namespace) {
+				return
+			}
 			log.Trace().Str("service", newService.Name).Str("namespace", newService.Namespace).Msg("k8s service updated")
-			update(oldService, newService)
+			handler.update(oldService, newService)
 		},
 		DeleteFunc: func(obj any) {
 			service := obj.(*corev1.Service)
+			if c.isExcluded(service.Namespace) {
+				return
+			}
 			log.Trace().Str("service", service.Name).Str("namespace", service.Namespace).Msg("k8s service deleted")
-			delete(service)
+			handler.delete(service)
 		},
-	}); err != nil {
+	})
+	if err != nil {
 		log.Fatal().Msg("failed to add service event handler")
 	}
+	return registration
 }
 
-func (c *Client) ServicesByPod(pod *corev1.Pod) []*corev1.Service {
-	services, err := c.service.lister.Services(pod.Namespace).List(labels.Everything())
-	if err != nil {
-		log.Error().Err(err).Msg("Error while fetching services")
-		return []*corev1.Service{}
+func (c *Client) WatchEndpointSlices(add func(endpointSlice *discoveryv1.EndpointSlice), update func(old *discoveryv1.EndpointSlice, new *discoveryv1.EndpointSlice), delete func(endpointSlice *discoveryv1.EndpointSlice)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	handler := endpointSliceHandler{add: add, update: update, delete: delete}
+	c.handlers.endpointSlice = append(c.handlers.endpointSlice, handler)
+	for _, informers := range c.namespaces {
+		if informers.endpointSlice.informer == nil {
+			continue
+		}
+		informers.registrations = append(informers.registrations, c.watchEndpointSlices(informers.endpointSlice.informer, handler))
 	}
+}
 
-	podLabels := labels.Set(pod.Labels)
-	var result []*corev1.Service
+func (c *Client) watchEndpointSlices(informer cache.SharedIndexInformer, handler endpointSliceHandler) cache.ResourceEventHandlerRegistration {
+	registration, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj any) {
+			endpointSlice := obj.(*discoveryv1.EndpointSlice)
+			if c.isExcluded(endpointSlice.Namespace) {
+				return
+			}
+			log.Trace().Str("endpointSlice", endpointSlice.Name).Str("namespace", endpointSlice.Namespace).Msg("k8s endpoint slice added")
+			handler.add(endpointSlice)
+		},
+		UpdateFunc: func(oldObj, newObj any) {
+			oldEndpointSlice := oldObj.(*discoveryv1.EndpointSlice)
+			newEndpointSlice := newObj.(*discoveryv1.EndpointSlice)
+			if c.isExcluded(newEndpointSlice.Namespace) {
+				return
+			}
+			log.Trace().Str("endpointSlice", newEndpointSlice.Name).Str("namespace", newEndpointSlice.Namespace).Msg("k8s endpoint slice updated")
+			handler.update(oldEndpointSlice, newEndpointSlice)
+		},
+		DeleteFunc: func(obj any) {
+			endpointSlice := obj.(*discoveryv1.EndpointSlice)
+			if c.isExcluded(endpointSlice.Namespace) {
+				return
+			}
+			log.Trace().Str("endpointSlice", endpointSlice.Name).Str("namespace", endpointSlice.Namespace).Msg("k8s endpoint slice deleted")
+			handler.delete(endpointSlice)
+		},
+	})
+	if err != nil {
+		log.Fatal().Msg("failed to add endpoint slice event handler")
+	}
+	return registration
+}
 
-	for _, service := range services {
-		if len(service.Spec.Selector) == 0 {
-			continue
+// ServiceByEndpointSlice returns the service owning the endpoint slice, or nil if the service does not exist (anymore).
+func (c *Client) ServiceByEndpointSlice(endpointSlice *discoveryv1.EndpointSlice) *corev1.Service {
+	name := endpointSlice.Labels[discoveryv1.LabelServiceName]
+	if name == "" {
+		return nil
+	}
+	informers := c.informersFor(endpointSlice.Namespace)
+	if informers == nil {
+		return nil
+	}
+	service, err := informers.service.lister.Services(endpointSlice.Namespace).Get(name)
+	if err != nil {
+		if !apierrors.IsNotFound(err) {
+			log.Error().Err(err).Msg("Error while fetching service")
 		}
+		return nil
+	}
+	return service
+}
 
-		selector := labels.SelectorFromSet(service.Spec.Selector)
-		if selector.Matches(podLabels) {
+func (c *Client) EndpointSlicesByService(service *corev1.Service) []*discoveryv1.EndpointSlice {
+	informers := c.informersFor(service.Namespace)
+	if informers == nil || informers.endpointSlice.informer == nil {
+		return []*discoveryv1.EndpointSlice{}
+	}
+	objects, err := informers.endpointSlice.informer.GetIndexer().ByIndex(endpointSliceByServiceIndex, service.Namespace+"/"+service.Name)
+	if err != nil {
+		log.Error().Err(err).Msg("Error while fetching endpoint slices")
+		return []*discoveryv1.EndpointSlice{}
+	}
+	result := make([]*discoveryv1.EndpointSlice, 0, len(objects))
+	for _, obj := range objects {
+		result = append(result, obj.(*discoveryv1.EndpointSlice))
+	}
+	return result
+}
+
+// ServicesByPod returns the services whose endpoint slices reference the pod.
+func (c *Client) ServicesByPod(pod *corev1.Pod) []*corev1.Service {
+	informers := c.informersFor(pod.Namespace)
+	if informers == nil || informers.endpointSlice.informer == nil {
+		return []*corev1.Service{}
+	}
+	objects, err := informers.endpointSlice.informer.GetIndexer().ByIndex(endpointSliceByPodIndex, pod.Namespace+"/"+pod.Name)
+	if err != nil {
+		log.Error().Err(err).Msg("Error while fetching endpoint slices")
+		return []*corev1.Service{}
+	}
+	var result []*corev1.Service
+	for _, obj := range objects {
+		service := c.ServiceByEndpointSlice(obj.(*discoveryv1.EndpointSlice))
+		if service != nil && !slices.ContainsFunc(result, func(s *corev1.Service) bool { return s.Name == service.Name }) {
 			result = append(result, service)
 		}
 	}
 	return result
 }
 
-func (c *Client) PodsByService(service *corev1.Service) []*corev1.Pod {
-	pods, err := c.pod.lister.Pods(service.Namespace).List(labels.Everything())
+// PodByName returns the pod with the given namespace and name, or nil if the pod is unknown. Pods not watched due to the
+// label selector pushed down to the pod informers are read from the API server.
+func (c *Client) PodByName(namespace string, name string) *corev1.Pod {
+	informers := c.informersFor(namespace)
+	if informers == nil {
+		return nil
+	}
+	pod, err := informers.servicePod.lister.Pods(namespace).Get(name)
+	if err == nil {
+		return pod
+	}
+	if informers.podLabelSelector == "" {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), podRequestTimeout)
+	defer cancel()
+	pod, err = c.clientset.CoreV1().Pods(namespace).Get(ctx, name, metav1.GetOptions{})
 	if err != nil {
-		log.Error().Err(err).Msg("Error while fetching pods")
-		return []*corev1.Pod{}
+		if !apierrors.IsNotFound(err) {
+			log.Warn().Err(err).Str("pod", name).Str("namespace", namespace).Msg("Failed to get pod.")
+		}
+		return nil
 	}
+	return pod
+}
 
-	if len(service.Spec.Selector) == 0 {
-		return []*corev1.Pod{}
+// PodByEndpoint returns the pod referenced by the endpoint, or nil if the endpoint is not backed by a known pod.
+func (c *Client) PodByEndpoint(endpointSlice *discoveryv1.EndpointSlice, endpoint discoveryv1.Endpoint) *corev1.Pod {
+	if !isPodEndpoint(endpoint) {
+		return nil
+	}
+	namespace := endpointNamespace(endpointSlice, endpoint)
+	informers := c.informersFor(namespace)
+	if informers == nil {
+		return nil
 	}
+	pod, err := informers.servicePod.lister.Pods(namespace).Get(endpoint.TargetRef.Name)
+	if err != nil {
+		if !apierrors.IsNotFound(err) {
+			log.Error().Err(err).Msg("Error while fetching pod")
+		}
+		return nil
+	}
+	return pod
+}
 
-	selector := labels.SelectorFromSet(service.Spec.Selector)
-	var result []*corev1.Pod
+// IsEndpointReady reports whether the endpoint is serving traffic and not terminating.
+func (c *Client) IsEndpointReady(endpoint discoveryv1.Endpoint) bool {
+	conditions := endpoint.Conditions
+	if conditions.Terminating != nil && *conditions.Terminating {
+		return false
+	}
+	if conditions.Serving != nil {
+		return *conditions.Serving
+	}
+	// unknown readiness must be interpreted as ready
+	return conditions.Ready == nil || *conditions.Ready
+}
+
+func endpointSliceServiceIndexFunc(obj any) ([]string, error) {
+	endpointSlice, ok := obj.(*discoveryv1.EndpointSlice)
+	if !ok {
+		return nil, nil
+	}
+	name := endpointSlice.Labels[discoveryv1.LabelServiceName]
+	if name == "" {
+		return nil, nil
+	}
+	return []string{endpointSlice.Namespace + "/" + name}, nil
+}
 
-	for _, pod := range pods {
-		if selector.Matches(labels.Set(pod.Labels)) {
-			result = append(result, pod)
+func endpointSlicePodIndexFunc(obj any) ([]string, error) {
+	endpointSlice, ok := obj.(*discoveryv1.EndpointSlice)
+	if !ok {
+		return nil, nil
+	}
+	var keys []string
+	for _, endpoint := range endpointSlice.Endpoints {
+		if isPodEndpoint(endpoint) {
+			keys = append(keys, endpointNamespace(endpointSlice, endpoint)+"/"+endpoint.TargetRef.Name)
 		}
 	}
-	return result
+	return keys, nil
+}
+
+func isPodEndpoint(endpoint discoveryv1.Endpoint) bool {
+	return endpoint.TargetRef != nil && endpoint.TargetRef.Kind == "Pod"
+}
+
+func endpointNamespace(endpointSlice *discoveryv1.EndpointSlice, endpoint discoveryv1.Endpoint) string {
+	if endpoint.TargetRef != nil && endpoint.TargetRef.Namespace != "" {
+		return endpoint.TargetRef.Namespace
+	}
+	return endpointSlice.Namespace
 }
 
 func (c *Client) IsPodRunningAndReady(pod *corev1.Pod) bool {
@@ -0,0 +1,63 @@
+package client
+
+import (
+	"time"
+
+	"github.com/rs/zerolog/log"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	eventComponent = "steadybit-extension-auto-registration"
+	// identical events of the same object are emitted at most once within this window
+	eventDeduplicationWindow = 10 * time.Minute
+)
+
+// StartEventRecorder enables Kubernetes events. Events are sent until the stop channel is closed.
+func (c *Client) StartEventRecorder(clientset kubernetes.Interface, stopCh <-chan struct{}) {
+	broadcaster := record.NewBroadcaster(record.WithCorrelatorOptions(record.CorrelatorOptions{
+		// at most one event per object every 30 seconds after a burst of 10
+		BurstSize: 10,
+		QPS:       1. / 30,
+	}))
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: clientset.CoreV1().Events("")})
+	c.events.mu.Lock()
+	c.events.recorder = broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: eventComponent})
+	c.events.recent = make(map[string]time.Time)
+	c.events.mu.Unlock()
+	go func() {
+		<-stopCh
+		broadcaster.Shutdown()
+	}()
+	log.Info().Msg("Kubernetes events enabled.")
+}
+
+// RecordEvent records an event for the referenced object. Identical events are deduplicated. It is a no-op if events
+// are not enabled.
+func (c *Client) RecordEvent(ref *corev1.ObjectReference, eventType, reason, message string) {
+	if ref == nil {
+		return
+	}
+	c.events.mu.Lock()
+	defer c.events.mu.Unlock()
+	if c.events.recorder == nil {
+		return
+	}
+
+	now := time.Now()
+	key := string(ref.UID) + "/" + ref.Namespace + "/" + ref.Name + "/" + reason + "/" + message
+	if last, ok := c.events.recent[key]; ok && now.Sub(last) < eventDeduplicationWindow {
+		return
+	}
+	for k, last := range c.events.recent {
+		if now.Sub(last) >= eventDeduplicationWindow {
+			delete(c.events.recent, k)
+		}
+	}
+	c.events.recent[key] = now
+	c.events.recorder.Event(ref, eventType, reason, message)
+}
@@ -0,0 +1,397 @@
+package client
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	extconfig "github.com/steadybit/extension-auto-registration-kubernetes/config"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	listerCorev1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// namespaceInformers contains the informers of a single watched namespace, or of the whole cluster.
+type namespaceInformers struct {
+	// pod contains the pods relevant for pod-level registrations, limited to the local node in node-local mode.
+	pod struct {
+		lister   listerCorev1.PodLister
+		informer cache.SharedIndexInformer
+	}
+	// servicePod contains the pods of the namespace backing service-level registrations.
+	servicePod struct {
+		lister   listerCorev1.PodLister
+		informer cache.SharedIndexInformer
+	}
+	service struct {
+		lister   listerCorev1.ServiceLister
+		informer cache.SharedIndexInformer
+	}
+	// endpointSlice is nil if the endpoint slices are not watched
+	endpointSlice struct {
+		informer cache.SharedIndexInformer
+	}
+	// podLabelSelector is the label selector pushed down to the pod informers, empty if all pods are watched
+	podLabelSelector string
+	// registrations contains the registrations of the handlers at the informers
+	registrations []cache.ResourceEventHandlerRegistration
+	factories     []informers.SharedInformerFactory
+	stop          chan struct{}
+}
+
+func newNamespaceInformers(clientset kubernetes.Interface, namespace string, exclude []string, watchEndpointSlices bool) *namespaceInformers {
+	result := &namespaceInformers{stop: make(chan struct{})}
+
+	// excluded namespaces are filtered server-side if the whole cluster is watched
+	fieldSelector := fields.Everything()
+	if namespace == metav1.NamespaceAll {
+		for _, excluded := range exclude {
+			fieldSelector = fields.AndSelectors(fieldSelector, fields.OneTermNotEqualSelector("metadata.namespace", excluded))
+		}
+	}
+	factory := informers.NewSharedInformerFactoryWithOptions(clientset, 0,
+		informers.WithNamespace(namespace),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.FieldSelector = fieldSelector.String()
+		}))
+	result.factories = append(result.factories, factory)
+
+	// the include selector is pushed down to the pod informers to reduce the watch traffic
+	labelSelector := ""
+	if selector, err := extconfig.Config.MatchLabels.Selector(); err != nil {
+		log.Fatal().Err(err).Msg("Invalid label selector.")
+	} else {
+		labelSelector = selector.String()
+	}
+	podFactory := factory
+	result.podLabelSelector = labelSelector
+	if labelSelector != "" {
+		podFactory = informers.NewSharedInformerFactoryWithOptions(clientset, 0,
+			informers.WithNamespace(namespace),
+			informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+				options.FieldSelector = fieldSelector.String()
+				options.LabelSelector = labelSelector
+			}))
+		result.factories = append(result.factories, podFactory)
+	}
+
+	servicePods := podFactory.Core().V1().Pods()
+	result.servicePod.informer = servicePods.Informer()
+	result.servicePod.lister = servicePods.Lister()
+	if err := result.servicePod.informer.SetTransform(transformPod); err != nil {
+		log.Fatal().Err(err).Msg("Failed to add pod transformer")
+	}
+
+	if extconfig.Config.NodeLocalRegistration {
+		nodeFieldSelector := fields.AndSelectors(fieldSelector, fields.OneTermEqualSelector("spec.nodeName", extconfig.Config.NodeName))
+		nodeFactory := informers.NewSharedInformerFactoryWithOptions(clientset, 0,
+			informers.WithNamespace(namespace),
+			informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+				options.FieldSelector = nodeFieldSelector.String()
+				options.LabelSelector = labelSelector
+			}))
+		result.factories = append(result.factories, nodeFactory)
+		pods := nodeFactory.Core().V1().Pods()
+		result.pod.informer = pods.Informer()
+		result.pod.lister = pods.Lister()
+		if err := result.pod.informer.SetTransform(transformPod); err != nil {
+			log.Fatal().Err(err).Msg("Failed to add pod transformer")
+		}
+	} else {
+		result.pod = result.servicePod
+	}
+
+	services := factory.Core().V1().Services()
+	result.service.informer = services.Informer()
+	result.service.lister = services.Lister()
+	if err := result.service.informer.SetTransform(transformService); err != nil {
+		log.Fatal().Err(err).Msg("Failed to add service transformer")
+	}
+
+	countEvents(result.servicePod.informer, "pods")
+	countEvents(result.service.informer, "services")
+	if watchEndpointSlices {
+		endpointSlices := factory.Discovery().V1().EndpointSlices()
+		result.endpointSlice.informer = endpointSlices.Informer()
+		if err := result.endpointSlice.informer.SetTransform(transformEndpointSlice); err != nil {
+			log.Fatal().Err(err).Msg("Failed to add endpoint slice transformer")
+		}
+		if err := result.endpointSlice.informer.AddIndexers(cache.Indexers{
+			endpointSliceByServiceIndex: endpointSliceServiceIndexFunc,
+			endpointSliceByPodIndex:     endpointSlicePodIndexFunc,
+		}); err != nil {
+			log.Fatal().Err(err).Msg("Failed to add endpoint slice indexers")
+		}
+		countEvents(result.endpointSlice.informer, "endpointslices")
+	}
+	return result
+}
+
+// start starts the informers until either the namespace is removed or the stop channel is closed.
+func (n *namespaceInformers) start(stopCh <-chan struct{}) {
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-stopCh:
+		case <-n.stop:
+		}
+		close(done)
+	}()
+	for _, factory := range n.factories {
+		factory.Start(done)
+	}
+}
+
+func (n *namespaceInformers) hasSynced() bool {
+	return n.pod.informer.HasSynced() && n.servicePod.informer.HasSynced() && n.service.informer.HasSynced() && (n.endpointSlice.informer == nil || n.endpointSlice.informer.HasSynced())
+}
+
+// watchedNamespaces returns the configured namespaces without the excluded ones. It returns the cluster-wide namespace ""
+// if no namespaces are configured.
+func watchedNamespaces() []string {
+	configured := slices.Clone(extconfig.Config.Namespaces)
+	if extconfig.Config.NamespaceFilter != "" {
+		configured = append(configured, extconfig.Config.NamespaceFilter)
+	}
+	result := make([]string, 0, len(configured))
+	for _, namespace := range configured {
+		namespace = strings.TrimSpace(namespace)
+		if namespace != "" && !slices.Contains(result, namespace) && !slices.Contains(extconfig.Config.NamespacesExclude, namespace) {
+			result = append(result, namespace)
+		}
+	}
+	if len(configured) == 0 {
+		return []string{metav1.NamespaceAll}
+	}
+	if len(result) == 0 {
+		log.Warn().Msg("All configured namespaces are excluded. Nothing will be discovered.")
+	}
+	return result
+}
+
+func namespaceSelector() labels.Selector {
+	selector, err := extconfig.Config.NamespaceSelector.Selector()
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid namespace selector.")
+	}
+	return selector
+}
+
+func (c *Client) isExcluded(namespace string) bool {
+	return slices.Contains(c.exclude, namespace)
+}
+
+// informersFor returns the informers responsible for the namespace, or nil if the namespace is not watched.
+func (c *Client) informersFor(namespace string) *namespaceInformers {
+	if c.isExcluded(namespace) {
+		return nil
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if informers, ok := c.namespaces[namespace]; ok {
+		return informers
+	}
+	return c.namespaces[metav1.NamespaceAll]
+}
+
+// addNamespace starts to watch the namespace. The registered handlers are notified about all objects of the namespace.
+func (c *Client) addNamespace(namespace string) {
+	c.mu.Lock()
+	if _, ok := c.namespaces[namespace]; ok {
+		c.mu.Unlock()
+		return
+	}
+	informers := newNamespaceInformers(c.clientset, namespace, c.exclude, c.serviceDiscovery)
+	for _, handler := range c.handlers.pod {
+		informers.registrations = append(informers.registrations, c.watchPods(informers.pod.informer, handler))
+	}
+	for _, handler := range c.handlers.servicePod {
+		informers.registrations = append(informers.registrations, c.watchPods(informers.servicePod.informer, handler))
+	}
+	for _, handler := range c.handlers.service {
+		informers.registrations = append(informers.registrations, c.watchServices(informers.service.informer, handler))
+	}
+	for _, handler := range c.handlers.endpointSlice {
+		if informers.endpointSlice.informer != nil {
+			informers.registrations = append(informers.registrations, c.watchEndpointSlices(informers.endpointSlice.informer, handler))
+		}
+	}
+	c.namespaces[namespace] = informers
+	c.mu.Unlock()
+
+	informers.start(c.stopCh)
+	if namespace == metav1.NamespaceAll {
+		log.Info().Strs("excluded", c.exclude).Msg("Watching all namespaces.")
+	} else {
+		log.Info().Str("namespace", namespace).Msg("Watching namespace.")
+	}
+}
+
+// removeNamespace stops to watch the namespace. The registered handlers are notified about the deletion of all objects
+// of the namespace.
+func (c *Client) removeNamespace(namespace string) {
+	c.mu.Lock()
+	informers, ok := c.namespaces[namespace]
+	delete(c.namespaces, namespace)
+	handlers := c.handlers
+	c.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	close(informers.stop)
+	log.Info().Str("namespace", namespace).Msg("Stopped watching namespace.")
+	for _, obj := range informers.pod.informer.GetStore().List() {
+		for _, handler := range handlers.pod {
+			handler.delete(obj.(*corev1.Pod))
+		}
+	}
+	for _, obj := range informers.servicePod.informer.GetStore().List() {
+		for _, handler := range handlers.servicePod {
+			handler.delete(obj.(*corev1.Pod))
+		}
+	}
+	for _, obj := range informers.service.informer.GetStore().List() {
+		for _, handler := range handlers.service {
+			handler.delete(obj.(*corev1.Service))
+		}
+	}
+	if informers.endpointSlice.informer == nil {
+		return
+	}
+	for _, obj := range informers.endpointSlice.informer.GetStore().List() {
+		for _, handler := range handlers.endpointSlice {
+			handler.delete(obj.(*discoveryv1.EndpointSlice))
+		}
+	}
+}
+
+// watchNamespaces watches the namespace objects. If a namespace selector is configured, the watched namespaces are added
+// and removed at runtime based on the selector.
+func (c *Client) watchNamespaces() {
+	factory := informers.NewSharedInformerFactoryWithOptions(c.clientset, 0,
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.LabelSelector = c.namespace.selector.String()
+		}))
+	namespaces := factory.Core().V1().Namespaces()
+	c.namespace.informer = namespaces.Informer()
+	c.namespace.lister = namespaces.Lister()
+	if err := c.namespace.informer.SetTransform(transformNamespace); err != nil {
+		log.Fatal().Err(err).Msg("Failed to add namespace transformer")
+	}
+	countEvents(c.namespace.informer, "namespaces")
+	selecting := !c.namespace.selector.Empty()
+	registration, err := c.namespace.informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj any) {
+			if selecting {
+				c.namespaceChanged(obj.(*corev1.Namespace))
+			}
+		},
+		UpdateFunc: func(oldObj, newObj any) {
+			if selecting {
+				c.namespaceChanged(newObj.(*corev1.Namespace))
+			}
+			c.mu.RLock()
+			handlers := c.handlers.namespace
+			c.mu.RUnlock()
+			for _, handler := range handlers {
+				handler.update(oldObj.(*corev1.Namespace), newObj.(*corev1.Namespace))
+			}
+		},
+		DeleteFunc: func(obj any) {
+			// the key of a cluster-scoped object is its name
+			name, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+			if err == nil && selecting {
+				c.removeNamespace(name)
+			}
+		},
+	})
+	if err != nil {
+		log.Fatal().Msg("failed to add namespace event handler")
+	}
+	c.namespace.registration = registration
+	if selecting {
+		log.Info().Str("selector", c.namespace.selector.String()).Strs("excluded", c.exclude).Msg("Watching namespaces matching the selector.")
+	}
+	factory.Start(c.stopCh)
+}
+
+// WatchNamespaces notifies about updated namespaces, e.g. changed namespace-level settings. Added and deleted namespaces
+// are not reported, their pods and services are reported instead.
+func (c *Client) WatchNamespaces(update func(old *corev1.Namespace, new *corev1.Namespace)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.handlers.namespace = append(c.handlers.namespace, namespaceHandler{update: update})
+}
+
+// NamespaceByName returns the namespace, or nil if the namespace is unknown or namespaces are not watched.
+func (c *Client) NamespaceByName(name string) *corev1.Namespace {
+	if c.namespace.lister == nil {
+		return nil
+	}
+	namespace, err := c.namespace.lister.Get(name)
+	if err != nil {
+		return nil
+	}
+	return namespace
+}
+
+// PodsByNamespace returns the pods of the namespace relevant for pod-level registrations.
+func (c *Client) PodsByNamespace(namespace string) []*corev1.Pod {
+	informers := c.informersFor(namespace)
+	if informers == nil {
+		return []*corev1.Pod{}
+	}
+	pods, err := informers.pod.lister.Pods(namespace).List(labels.Everything())
+	if err != nil {
+		log.Error().Err(err).Msg("Error while fetching pods")
+		return []*corev1.Pod{}
+	}
+	return pods
+}
+
+// ServicesByNamespace returns the services of the namespace.
+func (c *Client) ServicesByNamespace(namespace string) []*corev1.Service {
+	informers := c.informersFor(namespace)
+	if informers == nil {
+		return []*corev1.Service{}
+	}
+	services, err := informers.service.lister.Services(namespace).List(labels.Everything())
+	if err != nil {
+		log.Error().Err(err).Msg("Error while fetching services")
+		return []*corev1.Service{}
+	}
+	return services
+}
+
+func (c *Client) namespaceChanged(namespace *corev1.Namespace) {
+	if !c.isNamespaceSelected(namespace) {
+		c.removeNamespace(namespace.Name)
+		return
+	}
+	c.mu.RLock()
+	_, watched := c.namespaces[namespace.Name]
+	c.mu.RUnlock()
+	if watched {
+		return
+	}
+	if c.checkNamespacePermissions && checkPermissions(c.clientset, namespace.Name).HasErrors() {
+		log.Error().Str("namespace", namespace.Name).Msg("Required permissions are missing. Namespace is ignored.")
+		return
+	}
+	c.addNamespace(namespace.Name)
+}
+
+func (c *Client) isNamespaceSelected(namespace *corev1.Namespace) bool {
+	if c.isExcluded(namespace.Name) || !c.namespace.selector.Matches(labels.Set(namespace.Labels)) {
+		return false
+	}
+	configured := watchedNamespaces()
+	return slices.Contains(configured, metav1.NamespaceAll) || slices.Contains(configured, namespace.Name)
+}
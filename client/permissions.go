@@ -2,6 +2,7 @@ package client
 
 import (
 	"context"
+	"slices"
 
 	"github.com/rs/zerolog/log"
 	"github.com/steadybit/extension-auto-registration-kubernetes/config"
@@ -17,14 +18,17 @@ type PermissionCheckResult struct {
 type PermissionCheckOutcome string
 
 const (
-	ERROR PermissionCheckOutcome = "error"
-	OK    PermissionCheckOutcome = "ok"
+	ERROR   PermissionCheckOutcome = "error"
+	WARNING PermissionCheckOutcome = "warning"
+	OK      PermissionCheckOutcome = "ok"
 )
 
 type requiredPermission struct {
 	verbs    []string
 	group    string
 	resource string
+	// optional permissions only disable a feature if missing
+	optional bool
 }
 
 func (p *requiredPermission) Key(verb string) string {
@@ -42,16 +46,39 @@ var requiredPermissions = []requiredPermission{
 	{group: "", resource: "pods", verbs: []string{"get", "list", "watch"}},
 }
 
-func checkPermissions(client kubernetes.Interface) *PermissionCheckResult {
+// endpointSlicesPermission is required for service-level registrations, they are disabled without it
+var endpointSlicesPermission = requiredPermission{group: "discovery.k8s.io", resource: "endpointslices", verbs: []string{"get", "list", "watch"}, optional: true}
+
+var eventsPermission = requiredPermission{group: "", resource: "events", verbs: []string{"create"}, optional: true}
+
+// namespacesPermission is required to select the watched namespaces by labels
+var namespacesPermission = requiredPermission{group: "", resource: "namespaces", verbs: []string{"get", "list", "watch"}}
+
+// optionalNamespacesPermission is used without a namespace selector, it only disables the namespace-level settings
+var optionalNamespacesPermission = requiredPermission{group: namespacesPermission.group, resource: namespacesPermission.resource, verbs: namespacesPermission.verbs, optional: true}
+
+func optionalPermissions() []requiredPermission {
+	if config.Config.KubernetesEvents {
+		return []requiredPermission{endpointSlicesPermission, eventsPermission}
+	}
+	return []requiredPermission{endpointSlicesPermission}
+}
+
+// checkPermissions checks the permissions required in the namespace. The namespace "" checks cluster-wide permissions.
+func checkPermissions(client kubernetes.Interface, namespace string) *PermissionCheckResult {
+	return checkPermissionList(client, namespace, append(slices.Clone(requiredPermissions), optionalPermissions()...))
+}
+
+func checkPermissionList(client kubernetes.Interface, namespace string, permissions []requiredPermission) *PermissionCheckResult {
 	result := make(map[string]PermissionCheckOutcome)
 	reviews := client.AuthorizationV1().SelfSubjectAccessReviews()
 
-	for _, p := range requiredPermissions {
+	for _, p := range permissions {
 		for _, verb := range p.verbs {
 			sar := authorizationv1.SelfSubjectAccessReview{
 				Spec: authorizationv1.SelfSubjectAccessReviewSpec{
 					ResourceAttributes: &authorizationv1.ResourceAttributes{
-						Namespace: config.Config.NamespaceFilter,
+						Namespace: namespace,
 						Verb:      verb,
 						Resource:  p.resource,
 						Group:     p.group,
@@ -62,7 +89,9 @@ func checkPermissions(client kubernetes.Interface) *PermissionCheckResult {
 			if err != nil {
 				log.Error().Err(err).Msgf("Failed to check permission %s", p.Key(verb))
 			}
-			if err != nil || !review.Status.Allowed {
+			if (err != nil || !review.Status.Allowed) && p.optional {
+				result[p.Key(verb)] = WARNING
+			} else if err != nil || !review.Status.Allowed {
 				result[p.Key(verb)] = ERROR
 			} else {
 				result[p.Key(verb)] = OK
@@ -70,20 +99,23 @@ func checkPermissions(client kubernetes.Interface) *PermissionCheckResult {
 		}
 	}
 
-	logPermissionCheckResult(result)
+	logPermissionCheckResult(namespace, result)
 	return &PermissionCheckResult{
 		Permissions: result,
 	}
 }
 
-func logPermissionCheckResult(permissions map[string]PermissionCheckOutcome) {
-	log.Info().Msg("Permission check results:")
+func logPermissionCheckResult(namespace string, permissions map[string]PermissionCheckOutcome) {
+	log.Info().Str("namespace", namespace).Msg("Permission check results:")
 	allGood := true
 	for k, v := range permissions {
 		if v == OK {
 			log.Debug().Str("permission", k).Str("result", string(v)).Msg("Permission granted.")
 		} else if v == ERROR {
-			log.Error().Str("permission", k).Str("result", string(v)).Msg("Permission missing.")
+			log.Error().Str("permission", k).Str("namespace", namespace).Str("result", string(v)).Msg("Permission missing.")
+			allGood = false
+		} else if v == WARNING {
+			log.Warn().Str("permission", k).Str("namespace", namespace).Str("result", string(v)).Msg("Optional permission missing.")
 			allGood = false
 		}
 	}
@@ -92,6 +124,32 @@ func logPermissionCheckResult(permissions map[string]PermissionCheckOutcome) {
 	}
 }
 
+// merge combines the results of several namespaces. A permission is only granted if it is granted in all namespaces.
+func (result *PermissionCheckResult) merge(other *PermissionCheckResult) *PermissionCheckResult {
+	if result == nil {
+		return other
+	}
+	for k, v := range other.Permissions {
+		if existing, ok := result.Permissions[k]; !ok || existing == OK || v == ERROR {
+			result.Permissions[k] = v
+		}
+	}
+	return result
+}
+
+// IsGranted reports whether all verbs of the permission are granted.
+func (result *PermissionCheckResult) IsGranted(p requiredPermission) bool {
+	if result == nil {
+		return false
+	}
+	for _, verb := range p.verbs {
+		if result.Permissions[p.Key(verb)] != OK {
+			return false
+		}
+	}
+	return true
+}
+
 func (result *PermissionCheckResult) HasErrors() bool {
 	if result == nil {
 		return true
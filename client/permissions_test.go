@@ -3,8 +3,12 @@ package client
 import (
 	"testing"
 
+	"github.com/steadybit/extension-auto-registration-kubernetes/config"
 	"github.com/stretchr/testify/assert"
 	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	testclient "k8s.io/client-go/kubernetes/fake"
 	ktesting "k8s.io/client-go/testing"
@@ -49,8 +53,10 @@ func TestPermissionCheckResult_HasErrors(t *testing.T) {
 func TestCheckPermissions(t *testing.T) {
 	tests := []struct {
 		name             string
+		kubernetesEvents bool
 		setupReactions   func(*testclient.Clientset)
 		expectedOutcomes map[string]PermissionCheckOutcome
+		expectedErrors   bool
 	}{
 		{
 			name: "should return OK for allowed permissions",
@@ -64,12 +70,15 @@ func TestCheckPermissions(t *testing.T) {
 				})
 			},
 			expectedOutcomes: map[string]PermissionCheckOutcome{
-				"services/get":   OK,
-				"services/list":  OK,
-				"services/watch": OK,
-				"pods/get":       OK,
-				"pods/list":      OK,
-				"pods/watch":     OK,
+				"services/get":                          OK,
+				"services/list":                         OK,
+				"services/watch":                        OK,
+				"pods/get":                              OK,
+				"pods/list":                             OK,
+				"pods/watch":                            OK,
+				"discovery.k8s.io/endpointslices/get":   OK,
+				"discovery.k8s.io/endpointslices/list":  OK,
+				"discovery.k8s.io/endpointslices/watch": OK,
 			},
 		},
 		{
@@ -89,22 +98,86 @@ func TestCheckPermissions(t *testing.T) {
 				})
 			},
 			expectedOutcomes: map[string]PermissionCheckOutcome{
-				"services/get":   OK,
-				"services/list":  OK,
-				"services/watch": OK,
-				"pods/get":       ERROR,
-				"pods/list":      ERROR,
-				"pods/watch":     ERROR,
+				"services/get":                          OK,
+				"services/list":                         OK,
+				"services/watch":                        OK,
+				"pods/get":                              ERROR,
+				"pods/list":                             ERROR,
+				"pods/watch":                            ERROR,
+				"discovery.k8s.io/endpointslices/get":   WARNING,
+				"discovery.k8s.io/endpointslices/list":  WARNING,
+				"discovery.k8s.io/endpointslices/watch": WARNING,
+			},
+			expectedErrors: true,
+		},
+		{
+			name: "should only disable service-level registrations without endpoint slice permissions",
+			setupReactions: func(client *testclient.Clientset) {
+				client.PrependReactor("create", "selfsubjectaccessreviews", func(action ktesting.Action) (handled bool, ret runtime.Object, err error) {
+					createAction := action.(ktesting.CreateAction)
+					sar := createAction.GetObject().(*authorizationv1.SelfSubjectAccessReview)
+
+					allowed := sar.Spec.ResourceAttributes.Resource != "endpointslices"
+
+					return true, &authorizationv1.SelfSubjectAccessReview{
+						Status: authorizationv1.SubjectAccessReviewStatus{
+							Allowed: allowed,
+						},
+					}, nil
+				})
+			},
+			expectedOutcomes: map[string]PermissionCheckOutcome{
+				"services/get":                          OK,
+				"services/list":                         OK,
+				"services/watch":                        OK,
+				"pods/get":                              OK,
+				"pods/list":                             OK,
+				"pods/watch":                            OK,
+				"discovery.k8s.io/endpointslices/get":   WARNING,
+				"discovery.k8s.io/endpointslices/list":  WARNING,
+				"discovery.k8s.io/endpointslices/watch": WARNING,
+			},
+		},
+		{
+			name:             "should return WARNING for denied optional permissions",
+			kubernetesEvents: true,
+			setupReactions: func(client *testclient.Clientset) {
+				client.PrependReactor("create", "selfsubjectaccessreviews", func(action ktesting.Action) (handled bool, ret runtime.Object, err error) {
+					createAction := action.(ktesting.CreateAction)
+					sar := createAction.GetObject().(*authorizationv1.SelfSubjectAccessReview)
+
+					allowed := sar.Spec.ResourceAttributes.Resource != "events"
+
+					return true, &authorizationv1.SelfSubjectAccessReview{
+						Status: authorizationv1.SubjectAccessReviewStatus{
+							Allowed: allowed,
+						},
+					}, nil
+				})
+			},
+			expectedOutcomes: map[string]PermissionCheckOutcome{
+				"services/get":                          OK,
+				"services/list":                         OK,
+				"services/watch":                        OK,
+				"pods/get":                              OK,
+				"pods/list":                             OK,
+				"pods/watch":                            OK,
+				"discovery.k8s.io/endpointslices/get":   OK,
+				"discovery.k8s.io/endpointslices/list":  OK,
+				"discovery.k8s.io/endpointslices/watch": OK,
+				"events/create":                         WARNING,
 			},
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
+			config.Config.KubernetesEvents = tt.kubernetesEvents
+			defer func() { config.Config.KubernetesEvents = false }()
 			fakeClient := testclient.NewSimpleClientset()
 			tt.setupReactions(fakeClient)
 
-			result := checkPermissions(fakeClient)
+			result := checkPermissions(fakeClient, "")
 
 			assert.NotNil(t, result)
 			assert.Equal(t, len(tt.expectedOutcomes), len(result.Permissions))
@@ -114,6 +187,26 @@ func TestCheckPermissions(t *testing.T) {
 				assert.True(t, exists, "Expected permission %s to exist", permission)
 				assert.Equal(t, expectedOutcome, actualOutcome, "Permission %s outcome mismatch", permission)
 			}
+			assert.Equal(t, tt.expectedErrors, result.HasErrors())
 		})
 	}
 }
+
+func TestClientWithoutEndpointSlicePermission(t *testing.T) {
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	service := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "service"}}
+	endpointSlice := &discoveryv1.EndpointSlice{ObjectMeta: metav1.ObjectMeta{
+		Namespace: "default",
+		Name:      "service-abc",
+		Labels:    map[string]string{discoveryv1.LabelServiceName: service.Name},
+	}}
+
+	client := newClient(testclient.NewSimpleClientset(service, endpointSlice), stopCh)
+	client.start()
+
+	assert.False(t, client.ServiceDiscoveryEnabled())
+	assert.True(t, client.HasSynced(), "the caches should be synced without the endpoint slice informer")
+	assert.Empty(t, client.EndpointSlicesByService(service))
+	assert.Empty(t, client.ServicesByPod(&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "pod"}}))
+}
@@ -2,6 +2,7 @@ package client
 
 import (
 	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -9,55 +10,90 @@ func transformPod(i any) (any, error) {
 	//pod.Status.Phase
 	//pod.Status.Conditions
 	//pod.Status.PodIP
+	//pod.Status.PodIPs
 	//pod.ObjectMeta.Labels
 	//pod.Extensions
 	//pod.Name
 	//pod.Namespace
+	//pod.UID
+	//pod.Spec.InitContainers
 	//pod.Spec.Containers
+	//pod.Spec.NodeName
+	//pod.Spec.HostNetwork
+	//pod.Status.HostIP
+	//pod.Status.HostIPs
 	if pod, ok := i.(*corev1.Pod); ok {
 		pod.ObjectMeta = metav1.ObjectMeta{
 			Name:        pod.Name,
 			Namespace:   pod.Namespace,
+			UID:         pod.UID,
 			Labels:      pod.Labels,
 			Annotations: pod.Annotations,
 		}
 		newPodSpec := corev1.PodSpec{
-			Containers: make([]corev1.Container, 0, len(pod.Spec.Containers)),
-		}
-		for _, container := range pod.Spec.Containers {
-			newPodSpec.Containers = append(newPodSpec.Containers, corev1.Container{
-				Ports:          container.Ports,
-				LivenessProbe:  container.LivenessProbe,
-				ReadinessProbe: container.ReadinessProbe,
-			})
+			InitContainers: transformContainers(pod.Spec.InitContainers),
+			Containers:     transformContainers(pod.Spec.Containers),
+			NodeName:       pod.Spec.NodeName,
+			HostNetwork:    pod.Spec.HostNetwork,
 		}
 		pod.Spec = newPodSpec
 		pod.Status = corev1.PodStatus{
 			Phase:      pod.Status.Phase,
 			Conditions: pod.Status.Conditions,
 			PodIP:      pod.Status.PodIP,
+			PodIPs:     pod.Status.PodIPs,
+			HostIP:     pod.Status.HostIP,
+			HostIPs:    pod.Status.HostIPs,
 		}
 		return pod, nil
 	}
 	return i, nil
 }
 
+// transformContainers keeps the ports and probes of the containers. The restart policy marks init containers as
+// native sidecars.
+func transformContainers(containers []corev1.Container) []corev1.Container {
+	if len(containers) == 0 {
+		return nil
+	}
+	result := make([]corev1.Container, 0, len(containers))
+	for _, container := range containers {
+		result = append(result, corev1.Container{
+			Name:           container.Name,
+			Ports:          container.Ports,
+			LivenessProbe:  container.LivenessProbe,
+			ReadinessProbe: container.ReadinessProbe,
+			StartupProbe:   container.StartupProbe,
+			RestartPolicy:  container.RestartPolicy,
+		})
+	}
+	return result
+}
+
 func transformService(i any) (any, error) {
 	//service.Extensions
 	//service.Name
 	//service.Namespace
+	//service.UID
+	//service.Labels
 	//service.Spec.Selector
 	//service.Spec.Ports
+	//service.Spec.ClusterIP
+	//service.Spec.ClusterIPs
 	//service.Status.LoadBalancer
 	if s, ok := i.(*corev1.Service); ok {
 		s.ObjectMeta = metav1.ObjectMeta{
 			Name:        s.Name,
 			Namespace:   s.Namespace,
+			UID:         s.UID,
+			Labels:      s.Labels,
 			Annotations: s.Annotations,
 		}
 		s.Spec = corev1.ServiceSpec{
-			Selector: s.Spec.Selector,
-			Ports:    s.Spec.Ports,
+			Selector:   s.Spec.Selector,
+			Ports:      s.Spec.Ports,
+			ClusterIP:  s.Spec.ClusterIP,
+			ClusterIPs: s.Spec.ClusterIPs,
 		}
 		s.Status = corev1.ServiceStatus{
 			LoadBalancer: s.Status.LoadBalancer,
@@ -66,3 +102,44 @@ func transformService(i any) (any, error) {
 	}
 	return i, nil
 }
+
+func transformEndpointSlice(i any) (any, error) {
+	//endpointSlice.Name
+	//endpointSlice.Namespace
+	//endpointSlice.Labels
+	//endpointSlice.Endpoints
+	//endpointSlice.Ports
+	if e, ok := i.(*discoveryv1.EndpointSlice); ok {
+		e.ObjectMeta = metav1.ObjectMeta{
+			Name:      e.Name,
+			Namespace: e.Namespace,
+			Labels:    e.Labels,
+		}
+		for idx, endpoint := range e.Endpoints {
+			e.Endpoints[idx] = discoveryv1.Endpoint{
+				Addresses:  endpoint.Addresses,
+				Conditions: endpoint.Conditions,
+				TargetRef:  endpoint.TargetRef,
+			}
+		}
+		return e, nil
+	}
+	return i, nil
+}
+
+func transformNamespace(i any) (any, error) {
+	//namespace.Name
+	//namespace.Labels
+	//namespace.Annotations
+	if n, ok := i.(*corev1.Namespace); ok {
+		n.ObjectMeta = metav1.ObjectMeta{
+			Name:        n.Name,
+			Labels:      n.Labels,
+			Annotations: n.Annotations,
+		}
+		n.Spec = corev1.NamespaceSpec{}
+		n.Status = corev1.NamespaceStatus{}
+		return n, nil
+	}
+	return i, nil
+}
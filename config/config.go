@@ -4,6 +4,9 @@
 package config
 
 import (
+	"fmt"
+	"net/url"
+
 	"github.com/kelseyhightower/envconfig"
 	"github.com/rs/zerolog/log"
 )
@@ -17,4 +20,71 @@ func ParseConfiguration() {
 	if err != nil {
 		log.Fatal().Err(err).Msgf("Failed to parse configuration from environment.")
 	}
+	if Config.AgentRegistrationStrategy != StrategyMakeBeforeBreak && Config.AgentRegistrationStrategy != StrategyBreakBeforeMake {
+		log.Fatal().Msgf("Unknown agent registration strategy '%s'. Use '%s' or '%s'.", Config.AgentRegistrationStrategy, StrategyMakeBeforeBreak, StrategyBreakBeforeMake)
+	}
+	if Config.PreferredIpFamily != IpFamilyPrimary && Config.PreferredIpFamily != IpFamilyIPv4 && Config.PreferredIpFamily != IpFamilyIPv6 {
+		log.Fatal().Msgf("Unknown preferred ip family '%s'. Use '%s', '%s' or '%s'.", Config.PreferredIpFamily, IpFamilyPrimary, IpFamilyIPv4, IpFamilyIPv6)
+	}
+	if len(Config.Agents) > 0 {
+		if err := validateAgents(Config.Agents); err != nil {
+			log.Fatal().Err(err).Msg("Invalid list of agents (STEADYBIT_EXTENSION_AGENTS).")
+		}
+	} else if Config.AgentKey == "" && Config.AgentKeyFile == "" {
+		log.Fatal().Msg("The agent key is required, either via STEADYBIT_EXTENSION_AGENT_KEY or STEADYBIT_EXTENSION_AGENT_KEY_FILE.")
+	}
+	if Config.AgentUrl != "" {
+		if u, err := url.Parse(Config.AgentUrl); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			log.Fatal().Msgf("Invalid agent url '%s'. Use e.g. 'https://steadybit-agent.steadybit-agent:42899'.", Config.AgentUrl)
+		}
+	}
+	if (Config.AgentTlsCertFile == "") != (Config.AgentTlsKeyFile == "") {
+		log.Fatal().Msg("Client certificates for the agent require both the certificate file (STEADYBIT_EXTENSION_AGENT_TLS_CERT_FILE) and the key file (STEADYBIT_EXTENSION_AGENT_TLS_KEY_FILE).")
+	}
+	if Config.NodeLocalRegistration && Config.NodeName == "" {
+		log.Fatal().Msg("Node-local registration requires the node name (STEADYBIT_EXTENSION_NODE_NAME).")
+	}
+	if Config.StateFile == "" && !allAgentsHaveStateFile(Config.Agents) {
+		log.Warn().Msg("No state file configured (STEADYBIT_EXTENSION_STATE_FILE). The created registrations are only tracked in memory, registrations of a previous run are only adopted if they are still discovered.")
+	}
+}
+
+func allAgentsHaveStateFile(agents Agents) bool {
+	if len(agents) == 0 {
+		return false
+	}
+	for _, agent := range agents {
+		if agent.StateFile == "" {
+			return false
+		}
+	}
+	return true
+}
+
+func validateAgents(agents Agents) error {
+	names := make(map[string]bool)
+	for i, agent := range agents {
+		if agent.Name == "" {
+			return fmt.Errorf("agent %d has no name", i)
+		}
+		if names[agent.Name] {
+			return fmt.Errorf("agent name '%s' is not unique", agent.Name)
+		}
+		names[agent.Name] = true
+		if agent.Url == "" && agent.UnixSocket == "" {
+			return fmt.Errorf("agent '%s' requires a url or a unix socket", agent.Name)
+		}
+		if agent.Url != "" {
+			if u, err := url.Parse(agent.Url); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return fmt.Errorf("agent '%s' has an invalid url '%s'", agent.Name, agent.Url)
+			}
+		}
+		if agent.Key == "" && agent.KeyFile == "" {
+			return fmt.Errorf("agent '%s' requires a key or a key file", agent.Name)
+		}
+		if (agent.TlsCertFile == "") != (agent.TlsKeyFile == "") {
+			return fmt.Errorf("agent '%s' requires both the client certificate file and the key file", agent.Name)
+		}
+	}
+	return nil
 }
@@ -5,31 +5,222 @@ package config
 
 import (
 	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
 	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
 )
 
 type Specification struct {
-	AgentKey                            string        `json:"agentKey" split_words:"true" required:"true"`
-	AgentPort                           int           `json:"agentPort" split_words:"true" default:"42899"`
-	NamespaceFilter                     string        `json:"namespaceFilter" split_words:"true" required:"false"`
-	LogKubernetesHttpRequests           bool          `json:"LogKubernetesHttpRequests" split_words:"true" default:"false"`
-	MatchLabels                         Labels        `json:"matchLabels" split_words:"true" required:"false"`
-	MatchLabelsExclude                  Labels        `json:"matchLabelsExclude" split_words:"true" required:"false"`
-	AgentRegistrationInitialDelay       time.Duration `json:"agentRegistrationInitialDelay" split_words:"true" default:"25s"`
-	AgentRegistrationInterval           time.Duration `json:"agentRegistrationInterval" split_words:"true" default:"1s"`
-	AgentRegistrationIntervalAfterError time.Duration `json:"agentRegistrationIntervalAfterError" split_words:"true" default:"5s"`
+	AgentKey                               string        `json:"agentKey" split_words:"true" required:"false"`
+	AgentKeyFile                           string        `json:"agentKeyFile" split_words:"true" required:"false"`
+	AgentPort                              int           `json:"agentPort" split_words:"true" default:"42899"`
+	AgentUrl                               string        `json:"agentUrl" split_words:"true" required:"false"`
+	AgentUnixSocket                        string        `json:"agentUnixSocket" split_words:"true" required:"false"`
+	AgentTlsCaFile                         string        `json:"agentTlsCaFile" split_words:"true" required:"false"`
+	AgentTlsCertFile                       string        `json:"agentTlsCertFile" split_words:"true" required:"false"`
+	AgentTlsKeyFile                        string        `json:"agentTlsKeyFile" split_words:"true" required:"false"`
+	AgentRequestTimeout                    time.Duration `json:"agentRequestTimeout" split_words:"true" default:"10s"`
+	AgentRequestRetries                    int           `json:"agentRequestRetries" split_words:"true" default:"2"`
+	NamespaceFilter                        string        `json:"namespaceFilter" split_words:"true" required:"false"`
+	Namespaces                             []string      `json:"namespaces" split_words:"true" required:"false"`
+	NamespacesExclude                      []string      `json:"namespacesExclude" split_words:"true" required:"false"`
+	NamespaceSelector                      Labels        `json:"namespaceSelector" split_words:"true" required:"false"`
+	NodeName                               string        `json:"nodeName" split_words:"true" required:"false"`
+	NodeLocalRegistration                  bool          `json:"nodeLocalRegistration" split_words:"true" default:"false"`
+	LogKubernetesHttpRequests              bool          `json:"LogKubernetesHttpRequests" split_words:"true" default:"false"`
+	MatchLabels                            Labels        `json:"matchLabels" split_words:"true" required:"false"`
+	MatchLabelsExclude                     Labels        `json:"matchLabelsExclude" split_words:"true" required:"false"`
+	AgentRegistrationInitialDelay          time.Duration `json:"agentRegistrationInitialDelay" split_words:"true" default:"0s"`
+	AgentReadinessTimeout                  time.Duration `json:"agentReadinessTimeout" split_words:"true" default:"5m"`
+	AgentReadinessInterval                 time.Duration `json:"agentReadinessInterval" split_words:"true" default:"1s"`
+	AgentReadinessMaxInterval              time.Duration `json:"agentReadinessMaxInterval" split_words:"true" default:"15s"`
+	AgentRegistrationInterval              time.Duration `json:"agentRegistrationInterval" split_words:"true" default:"1s"`
+	AgentRegistrationIntervalAfterError    time.Duration `json:"agentRegistrationIntervalAfterError" split_words:"true" default:"5s"`
+	AgentRegistrationMaxIntervalAfterError time.Duration `json:"agentRegistrationMaxIntervalAfterError" split_words:"true" default:"5m"`
+	AgentRegistrationReconcileInterval     time.Duration `json:"agentRegistrationReconcileInterval" split_words:"true" default:"1m"`
+	AgentRegistrationStrategy              string        `json:"agentRegistrationStrategy" split_words:"true" default:"make-before-break"`
+	AgentDeregistrationGracePeriod         time.Duration `json:"agentDeregistrationGracePeriod" split_words:"true" default:"0s"`
+	KubernetesEvents                       bool          `json:"kubernetesEvents" split_words:"true" default:"true"`
+	DeregisterOnShutdown                   bool          `json:"deregisterOnShutdown" split_words:"true" default:"false"`
+	StateFile                              string        `json:"stateFile" split_words:"true" required:"false"`
+	ProtectedUrls                          []string      `json:"protectedUrls" split_words:"true" required:"false"`
+	DefaultHealthPort                      int           `json:"defaultHealthPort" split_words:"true" default:"8081"`
+	PreferredIpFamily                      string        `json:"preferredIpFamily" split_words:"true" default:"primary"`
+	RegisterViaHostPort                    bool          `json:"registerViaHostPort" split_words:"true" default:"false"`
+	Agents                                 Agents        `json:"agents" split_words:"true" required:"false"`
+}
+
+const (
+	// StrategyMakeBeforeBreak registers new extensions first and deregisters extensions only if all registrations succeeded.
+	StrategyMakeBeforeBreak = "make-before-break"
+	// StrategyBreakBeforeMake deregisters extensions first and registers new extensions afterward.
+	StrategyBreakBeforeMake = "break-before-make"
+)
+
+const (
+	// IpFamilyPrimary uses the primary IP of a pod, i.e. the first one reported by Kubernetes.
+	IpFamilyPrimary = "primary"
+	// IpFamilyIPv4 prefers the IPv4 address of a dual-stack pod.
+	IpFamilyIPv4 = "IPv4"
+	// IpFamilyIPv6 prefers the IPv6 address of a dual-stack pod.
+	IpFamilyIPv6 = "IPv6"
+)
+
+// AgentBaseUrl returns the url of the agent api. Without an agent url, the agent is expected on localhost at the agent
+// port.
+func (s Specification) AgentBaseUrl() string {
+	if s.AgentUrl != "" {
+		return strings.TrimSuffix(s.AgentUrl, "/")
+	}
+	return "http://localhost:" + strconv.Itoa(s.AgentPort)
 }
 
+// Labels is a label selector. It is either given as JSON list of labels or in the Kubernetes selector syntax, e.g.
+// `tier in (ext),!legacy`. All labels must match.
 type Labels []Label
 type Label struct {
 	Key   string `json:"key"`
 	Value string `json:"value"`
+	// Operator is one of In, NotIn, Exists and DoesNotExist. Without operator, the label must be equal to Value.
+	Operator string   `json:"operator,omitempty"`
+	Values   []string `json:"values,omitempty"`
 }
 
 func (j *Labels) UnmarshalText(text []byte) error {
-	if len(text) == 0 || string(text) == "[]" {
+	value := strings.TrimSpace(string(text))
+	if len(value) == 0 || value == "[]" {
 		*j = Labels{}
 		return nil
 	}
-	return json.Unmarshal(text, (*[]Label)(j))
+	if strings.HasPrefix(value, "[") {
+		if err := json.Unmarshal([]byte(value), (*[]Label)(j)); err != nil {
+			return err
+		}
+	} else {
+		parsed, err := parseSelector(value)
+		if err != nil {
+			return err
+		}
+		*j = parsed
+	}
+	_, err := j.Selector()
+	return err
+}
+
+// UnmarshalJSON accepts the labels as JSON list or as selector string, e.g. within the list of agents.
+func (j *Labels) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	var value string
+	if err := json.Unmarshal(data, &value); err == nil {
+		return j.UnmarshalText([]byte(value))
+	}
+	return j.UnmarshalText(data)
+}
+
+func parseSelector(value string) (Labels, error) {
+	selector, err := labels.Parse(value)
+	if err != nil {
+		return nil, err
+	}
+	requirements, _ := selector.Requirements()
+	result := make(Labels, 0, len(requirements))
+	for _, requirement := range requirements {
+		label := Label{Key: requirement.Key()}
+		switch requirement.Operator() {
+		case selection.Equals, selection.DoubleEquals:
+			label.Value = requirement.Values().List()[0]
+		case selection.NotEquals:
+			label.Operator = string(metav1.LabelSelectorOpNotIn)
+			label.Values = requirement.Values().List()
+		case selection.In:
+			label.Operator = string(metav1.LabelSelectorOpIn)
+			label.Values = requirement.Values().List()
+		case selection.NotIn:
+			label.Operator = string(metav1.LabelSelectorOpNotIn)
+			label.Values = requirement.Values().List()
+		case selection.Exists:
+			label.Operator = string(metav1.LabelSelectorOpExists)
+		case selection.DoesNotExist:
+			label.Operator = string(metav1.LabelSelectorOpDoesNotExist)
+		default:
+			return nil, fmt.Errorf("unsupported operator '%s' in label selector '%s'", requirement.Operator(), value)
+		}
+		result = append(result, label)
+	}
+	return result, nil
+}
+
+// Selector returns the labels as Kubernetes label selector. The selector is empty if no labels are given.
+func (j Labels) Selector() (labels.Selector, error) {
+	selector := labels.NewSelector()
+	for _, label := range j {
+		var operator selection.Operator
+		values := label.Values
+		switch metav1.LabelSelectorOperator(label.Operator) {
+		case "":
+			operator = selection.Equals
+			values = []string{label.Value}
+		case metav1.LabelSelectorOpIn:
+			operator = selection.In
+		case metav1.LabelSelectorOpNotIn:
+			operator = selection.NotIn
+		case metav1.LabelSelectorOpExists:
+			operator = selection.Exists
+		case metav1.LabelSelectorOpDoesNotExist:
+			operator = selection.DoesNotExist
+		default:
+			return nil, fmt.Errorf("unsupported operator '%s' for label '%s'", label.Operator, label.Key)
+		}
+		requirement, err := labels.NewRequirement(label.Key, operator, values)
+		if err != nil {
+			return nil, err
+		}
+		selector = selector.Add(*requirement)
+	}
+	return selector, nil
+}
+
+// Agents is a JSON list of agents the extensions are registered at. If given, it replaces the single agent configured by
+// the agent url, port, key and TLS settings.
+type Agents []Agent
+
+// Agent is an agent of Agents. The connection settings correspond to the settings of the single agent.
+type Agent struct {
+	Name        string `json:"name"`
+	Url         string `json:"url,omitempty"`
+	UnixSocket  string `json:"unixSocket,omitempty"`
+	Key         string `json:"key,omitempty"`
+	KeyFile     string `json:"keyFile,omitempty"`
+	TlsCaFile   string `json:"tlsCaFile,omitempty"`
+	TlsCertFile string `json:"tlsCertFile,omitempty"`
+	TlsKeyFile  string `json:"tlsKeyFile,omitempty"`
+	// Namespaces and MatchLabels limit the extensions registered at the agent. Optional.
+	Namespaces  []string `json:"namespaces,omitempty"`
+	MatchLabels Labels   `json:"matchLabels,omitempty"`
+	// StateFile persists the registrations owned at the agent. Defaults to the state file suffixed with the agent name.
+	StateFile string `json:"stateFile,omitempty"`
+}
+
+func (a *Agents) UnmarshalText(text []byte) error {
+	value := strings.TrimSpace(string(text))
+	if len(value) == 0 {
+		*a = Agents{}
+		return nil
+	}
+	return json.Unmarshal([]byte(value), (*[]Agent)(a))
+}
+
+// BaseUrl returns the url of the agent api. With a unix socket, the url defaults to http://localhost.
+func (a Agent) BaseUrl() string {
+	if a.Url == "" {
+		return "http://localhost"
+	}
+	return strings.TrimSuffix(a.Url, "/")
 }
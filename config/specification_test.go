@@ -0,0 +1,113 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+func TestLabels_UnmarshalText(t *testing.T) {
+	tests := []struct {
+		name          string
+		value         string
+		expected      Labels
+		matches       map[string]string
+		doesNotMatch  map[string]string
+		expectedError string
+	}{
+		{
+			name:     "empty",
+			value:    "",
+			expected: Labels{},
+		},
+		{
+			name:         "json list",
+			value:        `[{"key":"app","value":"extension-host"}]`,
+			expected:     Labels{{Key: "app", Value: "extension-host"}},
+			matches:      map[string]string{"app": "extension-host"},
+			doesNotMatch: map[string]string{"app": "extension-container"},
+		},
+		{
+			name:         "json list with expressions",
+			value:        `[{"key":"tier","operator":"In","values":["ext","infra"]},{"key":"legacy","operator":"DoesNotExist"}]`,
+			expected:     Labels{{Key: "tier", Operator: "In", Values: []string{"ext", "infra"}}, {Key: "legacy", Operator: "DoesNotExist"}},
+			matches:      map[string]string{"tier": "infra"},
+			doesNotMatch: map[string]string{"tier": "infra", "legacy": "true"},
+		},
+		{
+			name:         "selector syntax",
+			value:        "tier in (ext),!legacy,app=extension-host,env!=dev,team",
+			matches:      map[string]string{"tier": "ext", "app": "extension-host", "team": "a"},
+			doesNotMatch: map[string]string{"tier": "ext", "app": "extension-host", "team": "a", "env": "dev"},
+			expected: Labels{
+				{Key: "app", Value: "extension-host"},
+				{Key: "env", Operator: "NotIn", Values: []string{"dev"}},
+				{Key: "legacy", Operator: "DoesNotExist"},
+				{Key: "team", Operator: "Exists"},
+				{Key: "tier", Operator: "In", Values: []string{"ext"}},
+			},
+		},
+		{
+			name:          "unsupported operator",
+			value:         `[{"key":"tier","operator":"Gt","values":["1"]}]`,
+			expectedError: "unsupported operator 'Gt'",
+		},
+		{
+			name:          "invalid selector",
+			value:         "tier in (ext",
+			expectedError: "unable to parse requirement",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var result Labels
+			err := result.UnmarshalText([]byte(tt.value))
+			if tt.expectedError != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.expectedError)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, result)
+
+			selector, err := result.Selector()
+			require.NoError(t, err)
+			if tt.matches != nil {
+				assert.True(t, selector.Matches(labels.Set(tt.matches)))
+			}
+			if tt.doesNotMatch != nil {
+				assert.False(t, selector.Matches(labels.Set(tt.doesNotMatch)))
+			}
+		})
+	}
+}
+
+func TestSpecification_AgentBaseUrl(t *testing.T) {
+	assert.Equal(t, "http://localhost:42899", Specification{AgentPort: 42899}.AgentBaseUrl())
+	assert.Equal(t, "https://agent.steadybit-agent:42899", Specification{AgentPort: 42899, AgentUrl: "https://agent.steadybit-agent:42899/"}.AgentBaseUrl())
+}
+
+func TestAgents_UnmarshalText(t *testing.T) {
+	var agents Agents
+	err := agents.UnmarshalText([]byte(`[
+		{"name":"team-a","url":"https://agent-a:42899","keyFile":"/keys/a","namespaces":["team-a"],"matchLabels":"tier in (ext)"},
+		{"name":"team-b","unixSocket":"/run/agent.sock","key":"b","matchLabels":[{"key":"team","value":"b"}]}
+	]`))
+	require.NoError(t, err)
+	require.Len(t, agents, 2)
+	assert.Equal(t, "https://agent-a:42899", agents[0].BaseUrl())
+	assert.Equal(t, []string{"team-a"}, agents[0].Namespaces)
+	selector, err := agents[0].MatchLabels.Selector()
+	require.NoError(t, err)
+	assert.True(t, selector.Matches(labels.Set{"tier": "ext"}))
+	assert.Equal(t, "http://localhost", agents[1].BaseUrl())
+	assert.Equal(t, Labels{{Key: "team", Value: "b"}}, agents[1].MatchLabels)
+	assert.NoError(t, validateAgents(agents))
+
+	assert.ErrorContains(t, validateAgents(Agents{{Name: "a", Url: "https://agent:42899", Key: "a"}, {Name: "a", Url: "https://agent:42899", Key: "a"}}), "not unique")
+	assert.ErrorContains(t, validateAgents(Agents{{Name: "a", Key: "a"}}), "requires a url or a unix socket")
+	assert.ErrorContains(t, validateAgents(Agents{{Name: "a", Url: "https://agent:42899"}}), "requires a key")
+	assert.ErrorContains(t, validateAgents(Agents{{Name: "a", Url: "agent:42899", Key: "a"}}), "invalid url")
+}
@@ -2,15 +2,20 @@ package autoregistration
 
 import (
 	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
 	"testing"
 	"time"
 
-	"github.com/go-resty/resty/v2"
 	"github.com/steadybit/extension-auto-registration-kubernetes/autoregistration"
 	"github.com/steadybit/extension-auto-registration-kubernetes/client"
 	"github.com/steadybit/extension-auto-registration-kubernetes/config"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/client-go/kubernetes"
@@ -18,19 +23,35 @@ import (
 )
 
 type TestSupport struct {
-	addPod           func(*corev1.Pod)
-	deletePod        func(*corev1.Pod)
-	updatePod        func(*corev1.Pod)
-	addService       func(*corev1.Service)
-	deleteService    func(*corev1.Service)
-	updateService    func(*corev1.Service)
-	getRegistrations func() (added []string, removed []string)
+	addPod func(*corev1.Pod)
+	// addPodWithoutSync adds the pod without waiting for the sync, e.g. if the sync is expected to fail
+	addPodWithoutSync   func(*corev1.Pod)
+	deletePod           func(*corev1.Pod)
+	updatePod           func(*corev1.Pod)
+	addService          func(*corev1.Service)
+	deleteService       func(*corev1.Service)
+	updateService       func(*corev1.Service)
+	addEndpointSlice    func(*discoveryv1.EndpointSlice)
+	updateEndpointSlice func(*discoveryv1.EndpointSlice)
+	addNamespace        func(*corev1.Namespace)
+	updateNamespace     func(*corev1.Namespace)
+	restartAgent        func()
+	getEvents           func() []corev1.Event
+	registrator         *autoregistration.AutoRegistration
+	getRegistrations    func() (added []string, removed []string)
 }
 
 func TestAutoRegistration_should_register_extensions(t *testing.T) {
 	type args struct {
-		matchLabels        config.Labels
-		matchLabelsExclude config.Labels
+		matchLabels         config.Labels
+		matchLabelsExclude  config.Labels
+		nodeName            string
+		gracePeriod         time.Duration
+		namespaces          []string
+		namespacesExclude   []string
+		namespaceSelector   config.Labels
+		preferredIpFamily   string
+		registerViaHostPort bool
 	}
 	tests := []struct {
 		name string
@@ -43,7 +64,7 @@ func TestAutoRegistration_should_register_extensions(t *testing.T) {
 				ts.addPod(getTestPod(nil))
 				added, _ := ts.getRegistrations()
 				assert.Len(t, added, 1, "There should be one added extension.")
-				assert.Equal(t, "{\"url\":\"http://192.168.1.1:8080\",\"restrictedPorts\":{\"8080\":\"ContainerPort\",\"8081\":\"LivenessProbe\",\"8082\":\"ReadinessProbe\"},\"restrictedIps\":[\"192.168.1.1\"]}", added[0])
+				assert.Equal(t, "{\"url\":\"http://192.168.1.1:8080\",\"restrictedPorts\":{\"8080\":\"ContainerPort (test-container)\",\"8081\":\"LivenessProbe (test-container)\",\"8082\":\"ReadinessProbe (test-container)\"},\"restrictedIps\":[\"192.168.1.1\"]}", added[0])
 			},
 		},
 		{
@@ -53,9 +74,10 @@ func TestAutoRegistration_should_register_extensions(t *testing.T) {
 				ts.addPod(getTestPod(func(p *corev1.Pod) {
 					p.ObjectMeta.Annotations = map[string]string{}
 				}))
+				ts.addEndpointSlice(getTestEndpointSlice(nil))
 				added, _ := ts.getRegistrations()
 				assert.Len(t, added, 1, "There should be one added extension.")
-				assert.Equal(t, "{\"url\":\"http://test-service.default.svc.cluster.local:8085\",\"restrictedPorts\":{\"8080\":\"ContainerPort\",\"8081\":\"LivenessProbe\",\"8082\":\"ReadinessProbe\",\"8085\":\"ServicePort\"},\"restrictedIps\":[\"555.555.555.555\",\"192.168.1.1\"]}", added[0])
+				assert.Equal(t, "{\"url\":\"http://test-service.default.svc.cluster.local:8085\",\"restrictedPorts\":{\"8080\":\"ContainerPort (test-container)\",\"8081\":\"LivenessProbe (test-container)\",\"8082\":\"ReadinessProbe (test-container)\",\"8085\":\"ServicePort\"},\"restrictedIps\":[\"555.555.555.555\",\"192.168.1.1\"]}", added[0])
 			},
 		},
 		{
@@ -65,9 +87,10 @@ func TestAutoRegistration_should_register_extensions(t *testing.T) {
 					p.ObjectMeta.Annotations = map[string]string{}
 				}))
 				ts.addService(getTestService(nil))
+				ts.addEndpointSlice(getTestEndpointSlice(nil))
 				added, _ := ts.getRegistrations()
 				assert.Len(t, added, 1, "There should be one added extension.")
-				assert.Equal(t, "{\"url\":\"http://test-service.default.svc.cluster.local:8085\",\"restrictedPorts\":{\"8080\":\"ContainerPort\",\"8081\":\"LivenessProbe\",\"8082\":\"ReadinessProbe\",\"8085\":\"ServicePort\"},\"restrictedIps\":[\"555.555.555.555\",\"192.168.1.1\"]}", added[0])
+				assert.Equal(t, "{\"url\":\"http://test-service.default.svc.cluster.local:8085\",\"restrictedPorts\":{\"8080\":\"ContainerPort (test-container)\",\"8081\":\"LivenessProbe (test-container)\",\"8082\":\"ReadinessProbe (test-container)\",\"8085\":\"ServicePort\"},\"restrictedIps\":[\"555.555.555.555\",\"192.168.1.1\"]}", added[0])
 			},
 		},
 		{
@@ -76,12 +99,12 @@ func TestAutoRegistration_should_register_extensions(t *testing.T) {
 				ts.addPod(getTestPod(nil))
 				added, _ := ts.getRegistrations()
 				assert.Len(t, added, 1, "There should be one added extension.")
-				assert.Equal(t, "{\"url\":\"http://192.168.1.1:8080\",\"restrictedPorts\":{\"8080\":\"ContainerPort\",\"8081\":\"LivenessProbe\",\"8082\":\"ReadinessProbe\"},\"restrictedIps\":[\"192.168.1.1\"]}", added[0])
+				assert.Equal(t, "{\"url\":\"http://192.168.1.1:8080\",\"restrictedPorts\":{\"8080\":\"ContainerPort (test-container)\",\"8081\":\"LivenessProbe (test-container)\",\"8082\":\"ReadinessProbe (test-container)\"},\"restrictedIps\":[\"192.168.1.1\"]}", added[0])
 				ts.deletePod(getTestPod(nil))
 				added, removed := ts.getRegistrations()
 				assert.Len(t, added, 1, "There should still only be one added extension.")
 				assert.Len(t, removed, 1, "There should be one removed extension.")
-				assert.Equal(t, "{\"url\":\"http://192.168.1.1:8080\",\"restrictedPorts\":{\"8080\":\"ContainerPort\",\"8081\":\"LivenessProbe\",\"8082\":\"ReadinessProbe\"},\"restrictedIps\":[\"192.168.1.1\"]}", removed[0])
+				assert.Equal(t, "{\"url\":\"http://192.168.1.1:8080\",\"restrictedPorts\":{\"8080\":\"ContainerPort (test-container)\",\"8081\":\"LivenessProbe (test-container)\",\"8082\":\"ReadinessProbe (test-container)\"},\"restrictedIps\":[\"192.168.1.1\"]}", removed[0])
 			},
 		},
 		{
@@ -90,14 +113,14 @@ func TestAutoRegistration_should_register_extensions(t *testing.T) {
 				ts.addPod(getTestPod(nil))
 				added, _ := ts.getRegistrations()
 				assert.Len(t, added, 1, "There should be one added extension.")
-				assert.Equal(t, "{\"url\":\"http://192.168.1.1:8080\",\"restrictedPorts\":{\"8080\":\"ContainerPort\",\"8081\":\"LivenessProbe\",\"8082\":\"ReadinessProbe\"},\"restrictedIps\":[\"192.168.1.1\"]}", added[0])
+				assert.Equal(t, "{\"url\":\"http://192.168.1.1:8080\",\"restrictedPorts\":{\"8080\":\"ContainerPort (test-container)\",\"8081\":\"LivenessProbe (test-container)\",\"8082\":\"ReadinessProbe (test-container)\"},\"restrictedIps\":[\"192.168.1.1\"]}", added[0])
 				ts.updatePod(getTestPod(func(p *corev1.Pod) {
 					p.ObjectMeta.Annotations = map[string]string{}
 				}))
 				added, removed := ts.getRegistrations()
 				assert.Len(t, added, 1, "There should still only be one added extension.")
 				assert.Len(t, removed, 1, "There should be one removed extension.")
-				assert.Equal(t, "{\"url\":\"http://192.168.1.1:8080\",\"restrictedPorts\":{\"8080\":\"ContainerPort\",\"8081\":\"LivenessProbe\",\"8082\":\"ReadinessProbe\"},\"restrictedIps\":[\"192.168.1.1\"]}", removed[0])
+				assert.Equal(t, "{\"url\":\"http://192.168.1.1:8080\",\"restrictedPorts\":{\"8080\":\"ContainerPort (test-container)\",\"8081\":\"LivenessProbe (test-container)\",\"8082\":\"ReadinessProbe (test-container)\"},\"restrictedIps\":[\"192.168.1.1\"]}", removed[0])
 			},
 		},
 		{
@@ -111,7 +134,7 @@ func TestAutoRegistration_should_register_extensions(t *testing.T) {
 				ts.updatePod(getTestPod(nil))
 				added, removed := ts.getRegistrations()
 				assert.Len(t, added, 1, "There should be one added extension.")
-				assert.Equal(t, "{\"url\":\"http://192.168.1.1:8080\",\"restrictedPorts\":{\"8080\":\"ContainerPort\",\"8081\":\"LivenessProbe\",\"8082\":\"ReadinessProbe\"},\"restrictedIps\":[\"192.168.1.1\"]}", added[0])
+				assert.Equal(t, "{\"url\":\"http://192.168.1.1:8080\",\"restrictedPorts\":{\"8080\":\"ContainerPort (test-container)\",\"8081\":\"LivenessProbe (test-container)\",\"8082\":\"ReadinessProbe (test-container)\"},\"restrictedIps\":[\"192.168.1.1\"]}", added[0])
 				assert.Empty(t, removed, "Nothing should be removed")
 			},
 		},
@@ -122,14 +145,15 @@ func TestAutoRegistration_should_register_extensions(t *testing.T) {
 				ts.addPod(getTestPod(func(p *corev1.Pod) {
 					p.ObjectMeta.Annotations = map[string]string{}
 				}))
+				ts.addEndpointSlice(getTestEndpointSlice(nil))
 				added, _ := ts.getRegistrations()
 				assert.Len(t, added, 1, "There should be one added extension.")
-				assert.Equal(t, "{\"url\":\"http://test-service.default.svc.cluster.local:8085\",\"restrictedPorts\":{\"8080\":\"ContainerPort\",\"8081\":\"LivenessProbe\",\"8082\":\"ReadinessProbe\",\"8085\":\"ServicePort\"},\"restrictedIps\":[\"555.555.555.555\",\"192.168.1.1\"]}", added[0])
+				assert.Equal(t, "{\"url\":\"http://test-service.default.svc.cluster.local:8085\",\"restrictedPorts\":{\"8080\":\"ContainerPort (test-container)\",\"8081\":\"LivenessProbe (test-container)\",\"8082\":\"ReadinessProbe (test-container)\",\"8085\":\"ServicePort\"},\"restrictedIps\":[\"555.555.555.555\",\"192.168.1.1\"]}", added[0])
 				ts.deleteService(getTestService(nil))
 				added, removed := ts.getRegistrations()
 				assert.Len(t, added, 1, "There should still only be one added extension.")
 				assert.Len(t, removed, 1, "There should be one removed extension.")
-				assert.Equal(t, "{\"url\":\"http://test-service.default.svc.cluster.local:8085\",\"restrictedPorts\":{\"8080\":\"ContainerPort\",\"8081\":\"LivenessProbe\",\"8082\":\"ReadinessProbe\",\"8085\":\"ServicePort\"},\"restrictedIps\":[\"555.555.555.555\",\"192.168.1.1\"]}", removed[0])
+				assert.Equal(t, "{\"url\":\"http://test-service.default.svc.cluster.local:8085\",\"restrictedPorts\":{\"8080\":\"ContainerPort (test-container)\",\"8081\":\"LivenessProbe (test-container)\",\"8082\":\"ReadinessProbe (test-container)\",\"8085\":\"ServicePort\"},\"restrictedIps\":[\"555.555.555.555\",\"192.168.1.1\"]}", removed[0])
 			},
 		},
 		{
@@ -139,16 +163,17 @@ func TestAutoRegistration_should_register_extensions(t *testing.T) {
 				ts.addPod(getTestPod(func(p *corev1.Pod) {
 					p.ObjectMeta.Annotations = map[string]string{}
 				}))
+				ts.addEndpointSlice(getTestEndpointSlice(nil))
 				added, _ := ts.getRegistrations()
 				assert.Len(t, added, 1, "There should be one added extension.")
-				assert.Equal(t, "{\"url\":\"http://test-service.default.svc.cluster.local:8085\",\"restrictedPorts\":{\"8080\":\"ContainerPort\",\"8081\":\"LivenessProbe\",\"8082\":\"ReadinessProbe\",\"8085\":\"ServicePort\"},\"restrictedIps\":[\"555.555.555.555\",\"192.168.1.1\"]}", added[0])
+				assert.Equal(t, "{\"url\":\"http://test-service.default.svc.cluster.local:8085\",\"restrictedPorts\":{\"8080\":\"ContainerPort (test-container)\",\"8081\":\"LivenessProbe (test-container)\",\"8082\":\"ReadinessProbe (test-container)\",\"8085\":\"ServicePort\"},\"restrictedIps\":[\"555.555.555.555\",\"192.168.1.1\"]}", added[0])
 				ts.updateService(getTestService(func(p *corev1.Service) {
 					p.ObjectMeta.Annotations = map[string]string{}
 				}))
 				added, removed := ts.getRegistrations()
 				assert.Len(t, added, 1, "There should still only be one added extension.")
 				assert.Len(t, removed, 1, "There should be one removed extension.")
-				assert.Equal(t, "{\"url\":\"http://test-service.default.svc.cluster.local:8085\",\"restrictedPorts\":{\"8080\":\"ContainerPort\",\"8081\":\"LivenessProbe\",\"8082\":\"ReadinessProbe\",\"8085\":\"ServicePort\"},\"restrictedIps\":[\"555.555.555.555\",\"192.168.1.1\"]}", added[0])
+				assert.Equal(t, "{\"url\":\"http://test-service.default.svc.cluster.local:8085\",\"restrictedPorts\":{\"8080\":\"ContainerPort (test-container)\",\"8081\":\"LivenessProbe (test-container)\",\"8082\":\"ReadinessProbe (test-container)\",\"8085\":\"ServicePort\"},\"restrictedIps\":[\"555.555.555.555\",\"192.168.1.1\"]}", added[0])
 			},
 		},
 		{
@@ -160,12 +185,403 @@ func TestAutoRegistration_should_register_extensions(t *testing.T) {
 				ts.addPod(getTestPod(func(p *corev1.Pod) {
 					p.ObjectMeta.Annotations = map[string]string{}
 				}))
+				ts.addEndpointSlice(getTestEndpointSlice(nil))
 				added, _ := ts.getRegistrations()
 				assert.Empty(t, added, "Nothing should be registered")
 				ts.updateService(getTestService(nil))
 				added, _ = ts.getRegistrations()
 				assert.Len(t, added, 1, "There should be one added extension.")
-				assert.Equal(t, "{\"url\":\"http://test-service.default.svc.cluster.local:8085\",\"restrictedPorts\":{\"8080\":\"ContainerPort\",\"8081\":\"LivenessProbe\",\"8082\":\"ReadinessProbe\",\"8085\":\"ServicePort\"},\"restrictedIps\":[\"555.555.555.555\",\"192.168.1.1\"]}", added[0])
+				assert.Equal(t, "{\"url\":\"http://test-service.default.svc.cluster.local:8085\",\"restrictedPorts\":{\"8080\":\"ContainerPort (test-container)\",\"8081\":\"LivenessProbe (test-container)\",\"8082\":\"ReadinessProbe (test-container)\",\"8085\":\"ServicePort\"},\"restrictedIps\":[\"555.555.555.555\",\"192.168.1.1\"]}", added[0])
+			},
+		},
+		{
+			name: "should register one aggregated extension for all pods of a service",
+			test: func(t *testing.T, ts TestSupport) {
+				ts.addService(getTestService(nil))
+				ts.addPod(getTestPod(func(p *corev1.Pod) {
+					p.ObjectMeta.Annotations = map[string]string{}
+				}))
+				ts.addPod(getTestPod(func(p *corev1.Pod) {
+					p.ObjectMeta.Name = "test-pod-2"
+					p.ObjectMeta.Annotations = map[string]string{}
+					p.Status.PodIP = "192.168.1.2"
+				}))
+				ts.addEndpointSlice(getTestEndpointSlice(func(e *discoveryv1.EndpointSlice) {
+					e.Endpoints = append(e.Endpoints, discoveryv1.Endpoint{
+						Addresses:  []string{"192.168.1.2"},
+						Conditions: e.Endpoints[0].Conditions,
+						TargetRef:  &corev1.ObjectReference{Kind: "Pod", Namespace: "default", Name: "test-pod-2"},
+					})
+				}))
+				added, _ := ts.getRegistrations()
+				assert.Len(t, added, 1, "There should be one added extension.")
+				assert.Equal(t, "{\"url\":\"http://test-service.default.svc.cluster.local:8085\",\"restrictedPorts\":{\"8080\":\"ContainerPort (test-container)\",\"8081\":\"LivenessProbe (test-container)\",\"8082\":\"ReadinessProbe (test-container)\",\"8085\":\"ServicePort\"},\"restrictedIps\":[\"555.555.555.555\",\"192.168.1.1\",\"192.168.1.2\"]}", added[0])
+
+				ts.updateEndpointSlice(getTestEndpointSlice(nil))
+				added, removed := ts.getRegistrations()
+				assert.Len(t, added, 2, "The registration should be updated.")
+				assert.Equal(t, "{\"url\":\"http://test-service.default.svc.cluster.local:8085\",\"restrictedPorts\":{\"8080\":\"ContainerPort (test-container)\",\"8081\":\"LivenessProbe (test-container)\",\"8082\":\"ReadinessProbe (test-container)\",\"8085\":\"ServicePort\"},\"restrictedIps\":[\"555.555.555.555\",\"192.168.1.1\"]}", added[1])
+				assert.Equal(t, added[:1], removed, "The outdated registration should be removed")
+				MU.RLock()
+				assert.Equal(t, added[1:], CurrentExtensions, "Only the updated registration should be left")
+				MU.RUnlock()
+			},
+		},
+		{
+			name: "should add service without selector with manually managed endpoints",
+			test: func(t *testing.T, ts TestSupport) {
+				ts.addService(getTestService(func(s *corev1.Service) {
+					s.Spec.Selector = nil
+				}))
+				ts.addEndpointSlice(getTestEndpointSlice(func(e *discoveryv1.EndpointSlice) {
+					e.Endpoints[0].Addresses = []string{"10.0.0.5"}
+					e.Endpoints[0].TargetRef = nil
+				}))
+				added, _ := ts.getRegistrations()
+				assert.Len(t, added, 1, "There should be one added extension.")
+				assert.Equal(t, "{\"url\":\"http://test-service.default.svc.cluster.local:8085\",\"restrictedPorts\":{\"8080\":\"EndpointPort\",\"8085\":\"ServicePort\"},\"restrictedIps\":[\"555.555.555.555\",\"10.0.0.5\"]}", added[0])
+			},
+		},
+		{
+			name: "should remove service registration when endpoint is terminating",
+			test: func(t *testing.T, ts TestSupport) {
+				ts.addService(getTestService(nil))
+				ts.addPod(getTestPod(func(p *corev1.Pod) {
+					p.ObjectMeta.Annotations = map[string]string{}
+				}))
+				ts.addEndpointSlice(getTestEndpointSlice(nil))
+				added, _ := ts.getRegistrations()
+				assert.Len(t, added, 1, "There should be one added extension.")
+				ts.updateEndpointSlice(getTestEndpointSlice(func(e *discoveryv1.EndpointSlice) {
+					e.Endpoints[0].Conditions = discoveryv1.EndpointConditions{
+						Ready:       new(false),
+						Serving:     new(true),
+						Terminating: new(true),
+					}
+				}))
+				added, removed := ts.getRegistrations()
+				assert.Len(t, added, 1, "There should still only be one added extension.")
+				assert.Len(t, removed, 1, "There should be one removed extension.")
+			},
+		},
+		{
+			name: "should ignore service without ready endpoints",
+			test: func(t *testing.T, ts TestSupport) {
+				ts.addService(getTestService(nil))
+				ts.addPod(getTestPod(func(p *corev1.Pod) {
+					p.ObjectMeta.Annotations = map[string]string{}
+				}))
+				ts.addEndpointSlice(getTestEndpointSlice(func(e *discoveryv1.EndpointSlice) {
+					e.Endpoints[0].Conditions = discoveryv1.EndpointConditions{
+						Ready:   new(false),
+						Serving: new(false),
+					}
+				}))
+				added, _ := ts.getRegistrations()
+				assert.Empty(t, added, "Nothing should be registered")
+			},
+		},
+		{
+			name: "should register extensions again after agent restart",
+			test: func(t *testing.T, ts TestSupport) {
+				ts.addPod(getTestPod(nil))
+				added, _ := ts.getRegistrations()
+				assert.Len(t, added, 1, "There should be one added extension.")
+				ts.restartAgent()
+				assert.Eventually(t, func() bool {
+					added, _ := ts.getRegistrations()
+					return len(added) == 2
+				}, 5*time.Second, 100*time.Millisecond, "The extension should be registered again.")
+				added, removed := ts.getRegistrations()
+				assert.Equal(t, added[0], added[1])
+				assert.Empty(t, removed, "Nothing should be removed")
+			},
+		},
+		{
+			name: "should register the new extension before deregistering the old one",
+			test: func(t *testing.T, ts TestSupport) {
+				ts.addPod(getTestPod(nil))
+				ts.updatePod(getTestPod(func(p *corev1.Pod) {
+					p.Status.PodIP = "192.168.1.2"
+				}))
+				MU.RLock()
+				defer MU.RUnlock()
+				assert.Equal(t, []string{"add http://192.168.1.1:8080", "add http://192.168.1.2:8080", "remove http://192.168.1.1:8080"}, Operations)
+			},
+		},
+		{
+			name: "should not retry permanently rejected registrations",
+			test: func(t *testing.T, ts TestSupport) {
+				MU.Lock()
+				RejectedUrls["http://192.168.1.2:8080"] = http.StatusBadRequest
+				MU.Unlock()
+				ts.addPod(getTestPod(func(p *corev1.Pod) {
+					p.Name = "test-pod-rejected"
+					p.Status.PodIP = "192.168.1.2"
+				}))
+				ts.addPod(getTestPod(nil))
+				time.Sleep(2 * time.Second)
+
+				MU.RLock()
+				operations := slices.Clone(Operations)
+				MU.RUnlock()
+				assert.Contains(t, operations, "add http://192.168.1.1:8080", "Other registrations should converge")
+				assert.Equal(t, 1, countOperations(operations, "reject http://192.168.1.2:8080"), "A permanently rejected registration should not be retried")
+				failures := ts.registrator.Registrations().Failures
+				assert.Len(t, failures, 1)
+				assert.True(t, failures[0].Permanent)
+				assert.True(t, ts.registrator.IsReady(), "Permanent failures should not affect the readiness")
+			},
+		},
+		{
+			name: "should retry transient failures with backoff",
+			test: func(t *testing.T, ts TestSupport) {
+				MU.Lock()
+				RejectedUrls["http://192.168.1.1:8080"] = http.StatusServiceUnavailable
+				MU.Unlock()
+				ts.addPodWithoutSync(getTestPod(nil))
+				assert.Eventually(t, func() bool {
+					MU.RLock()
+					defer MU.RUnlock()
+					return countOperations(Operations, "reject http://192.168.1.1:8080") >= 2
+				}, 5*time.Second, 100*time.Millisecond, "The registration should be retried.")
+				assert.False(t, ts.registrator.IsReady(), "Transient failures should affect the readiness")
+
+				MU.Lock()
+				delete(RejectedUrls, "http://192.168.1.1:8080")
+				MU.Unlock()
+				assert.Eventually(t, func() bool {
+					added, _ := ts.getRegistrations()
+					return len(added) == 1
+				}, 5*time.Second, 100*time.Millisecond, "The registration should succeed eventually.")
+				assert.Empty(t, ts.registrator.Registrations().Failures)
+			},
+		},
+		{
+			name: "should deregister extension after grace period",
+			args: args{
+				gracePeriod: 2 * time.Second,
+			},
+			test: func(t *testing.T, ts TestSupport) {
+				ts.addPod(getTestPod(nil))
+				ts.deletePod(getTestPod(nil))
+				_, removed := ts.getRegistrations()
+				assert.Empty(t, removed, "Nothing should be removed within the grace period")
+				assert.Eventually(t, func() bool {
+					_, removed := ts.getRegistrations()
+					return len(removed) == 1
+				}, 5*time.Second, 100*time.Millisecond, "The extension should be removed after the grace period.")
+			},
+		},
+		{
+			name: "should keep registration of flapping pod within grace period",
+			args: args{
+				gracePeriod: 5 * time.Second,
+			},
+			test: func(t *testing.T, ts TestSupport) {
+				ts.addPod(getTestPod(nil))
+				ts.updatePod(getTestPod(func(p *corev1.Pod) {
+					p.Status.Conditions = []corev1.PodCondition{}
+				}))
+				ts.updatePod(getTestPod(nil))
+				added, removed := ts.getRegistrations()
+				assert.Len(t, added, 1, "There should still only be one added extension.")
+				assert.Empty(t, removed, "Nothing should be removed")
+			},
+		},
+		{
+			name: "should never remove registrations not created by the auto registration",
+			test: func(t *testing.T, ts TestSupport) {
+				manualRegistration := "{\"url\":\"http://extension-manual:8080\"}"
+				MU.Lock()
+				CurrentExtensions = append(CurrentExtensions, manualRegistration)
+				MU.Unlock()
+				ts.addPod(getTestPod(nil))
+				ts.deletePod(getTestPod(nil))
+				added, removed := ts.getRegistrations()
+				assert.Len(t, added, 1, "There should be one added extension.")
+				assert.Len(t, removed, 1, "There should be one removed extension.")
+				assert.NotContains(t, removed, manualRegistration)
+				MU.RLock()
+				defer MU.RUnlock()
+				assert.Equal(t, []string{manualRegistration}, CurrentExtensions)
+			},
+		},
+		{
+			name: "should report registration status",
+			test: func(t *testing.T, ts TestSupport) {
+				ts.addPod(getTestPod(nil))
+				assert.True(t, ts.registrator.IsReady())
+				status := ts.registrator.Registrations()
+				assert.Len(t, status.Pods["default/test-pod"], 1)
+				assert.Empty(t, status.Services)
+				assert.Len(t, status.Agent, 1)
+				assert.Empty(t, status.AgentError)
+				assert.Empty(t, status.Pending.Add)
+				assert.Empty(t, status.Pending.Remove)
+			},
+		},
+		{
+			name: "should deregister owned extensions on shutdown",
+			test: func(t *testing.T, ts TestSupport) {
+				manualRegistration := "{\"url\":\"http://extension-manual:8080\"}"
+				MU.Lock()
+				CurrentExtensions = append(CurrentExtensions, manualRegistration)
+				MU.Unlock()
+				ts.addPod(getTestPod(nil))
+				ts.registrator.Stop(true)
+				_, removed := ts.getRegistrations()
+				assert.Len(t, removed, 1, "There should be one removed extension.")
+				MU.RLock()
+				defer MU.RUnlock()
+				assert.Equal(t, []string{manualRegistration}, CurrentExtensions)
+			},
+		},
+		{
+			name: "should register version 2 annotation",
+			test: func(t *testing.T, ts TestSupport) {
+				ts.addPod(getTestPod(func(p *corev1.Pod) {
+					p.ObjectMeta.Annotations = map[string]string{
+						"steadybit.com/extension-auto-registration": `{"version":2,"extensions":[` +
+							`{"name":"host","port":8080,"protocol":"http","types":["host"],"restrictedPorts":[9090],"restrictedIps":["10.0.0.1"]},` +
+							`{"name":"disabled","port":8083,"protocol":"http","enabled":false},` +
+							`{"name":"invalid","port":8084,"protocol":"ftp"}]}`,
+					}
+				}))
+				added, _ := ts.getRegistrations()
+				assert.Len(t, added, 1, "There should be one added extension.")
+				assert.Equal(t, "{\"url\":\"http://192.168.1.1:8080\",\"types\":[\"host\"],\"restrictedPorts\":{\"8080\":\"ContainerPort (test-container)\",\"8081\":\"LivenessProbe (test-container)\",\"8082\":\"ReadinessProbe (test-container)\",\"9090\":\"Annotation\"},\"restrictedIps\":[\"192.168.1.1\",\"10.0.0.1\"]}", added[0])
+			},
+		},
+		{
+			name: "should restrict named probe ports, startup probes and the annotation port",
+			test: func(t *testing.T, ts TestSupport) {
+				ts.addPod(getTestPod(func(p *corev1.Pod) {
+					p.ObjectMeta.Annotations = map[string]string{
+						"steadybit.com/extension-auto-registration": `{"extensions":[{"port":8085,"protocol":"http"}]}`,
+					}
+					p.Spec.Containers[0].Ports = []corev1.ContainerPort{{Name: "http", ContainerPort: 8080}, {Name: "health", ContainerPort: 8083}}
+					p.Spec.Containers[0].LivenessProbe.HTTPGet.Port = intstr.FromString("health")
+					p.Spec.Containers[0].StartupProbe = &corev1.Probe{
+						ProbeHandler: corev1.ProbeHandler{TCPSocket: &corev1.TCPSocketAction{Port: intstr.FromInt32(8084)}},
+					}
+				}))
+				added, _ := ts.getRegistrations()
+				assert.Len(t, added, 1, "There should be one added extension.")
+				assert.Equal(t, "{\"url\":\"http://192.168.1.1:8085\",\"restrictedPorts\":{\"8080\":\"ContainerPort (test-container)\",\"8082\":\"ReadinessProbe (test-container)\",\"8083\":\"LivenessProbe (test-container)\",\"8084\":\"StartupProbe (test-container)\",\"8085\":\"AnnotationPort\"},\"restrictedIps\":[\"192.168.1.1\"]}", added[0])
+			},
+		},
+		{
+			name: "should register dual-stack pod with the preferred ip family",
+			args: args{
+				preferredIpFamily: config.IpFamilyIPv6,
+			},
+			test: func(t *testing.T, ts TestSupport) {
+				ts.addPod(getTestPod(func(p *corev1.Pod) {
+					p.Status.PodIPs = []corev1.PodIP{{IP: "192.168.1.1"}, {IP: "fd00::1"}}
+				}))
+				added, _ := ts.getRegistrations()
+				assert.Len(t, added, 1, "There should be one added extension.")
+				assert.Contains(t, added[0], `"url":"http://[fd00::1]:8080"`)
+				assert.Contains(t, added[0], `"restrictedIps":["192.168.1.1","fd00::1"]`)
+			},
+		},
+		{
+			name: "should restrict the cluster ips of a dual-stack service",
+			test: func(t *testing.T, ts TestSupport) {
+				ts.addService(getTestService(func(s *corev1.Service) {
+					s.Spec.ClusterIP = "10.96.0.10"
+					s.Spec.ClusterIPs = []string{"10.96.0.10", "fd00:10::a"}
+				}))
+				ts.addPod(getTestPod(func(p *corev1.Pod) {
+					p.ObjectMeta.Annotations = map[string]string{}
+				}))
+				ts.addEndpointSlice(getTestEndpointSlice(nil))
+				added, _ := ts.getRegistrations()
+				assert.Len(t, added, 1, "There should be one added extension.")
+				assert.Contains(t, added[0], `"restrictedIps":["555.555.555.555","10.96.0.10","fd00:10::a","192.168.1.1"]`)
+			},
+		},
+		{
+			name: "should register via host ip and host port",
+			args: args{
+				registerViaHostPort: true,
+			},
+			test: func(t *testing.T, ts TestSupport) {
+				ts.addPod(getTestPod(func(p *corev1.Pod) {
+					p.Spec.Containers[0].Ports[0].HostPort = 18080
+					p.Status.HostIP = "172.16.0.1"
+				}))
+				added, _ := ts.getRegistrations()
+				assert.Len(t, added, 1, "There should be one added extension.")
+				assert.Contains(t, added[0], `"url":"http://172.16.0.1:18080"`)
+				assert.Contains(t, added[0], `"18080":"HostPort (test-container)"`)
+				assert.Contains(t, added[0], `"restrictedIps":["192.168.1.1","172.16.0.1"]`)
+			},
+		},
+		{
+			name: "should restrict the node ip of host network pods",
+			test: func(t *testing.T, ts TestSupport) {
+				ts.addPod(getTestPod(func(p *corev1.Pod) {
+					p.Spec.HostNetwork = true
+					p.Status.PodIP = "172.16.0.1"
+					p.Status.HostIPs = []corev1.HostIP{{IP: "172.16.0.1"}, {IP: "fd00:1::1"}}
+				}))
+				added, _ := ts.getRegistrations()
+				assert.Len(t, added, 1, "There should be one added extension.")
+				assert.Contains(t, added[0], `"url":"http://172.16.0.1:8080"`)
+				assert.Contains(t, added[0], `"restrictedIps":["172.16.0.1","fd00:1::1"]`)
+			},
+		},
+		{
+			name: "should record events for registrations and invalid annotations",
+			test: func(t *testing.T, ts TestSupport) {
+				ts.addPod(getTestPod(nil))
+				ts.addPod(getTestPod(func(p *corev1.Pod) {
+					p.Name = "test-pod-invalid"
+					p.Status.PodIP = "192.168.1.2"
+					p.ObjectMeta.Annotations = map[string]string{
+						"steadybit.com/extension-auto-registration": `{"extensions":[{"port":8080,"protocol":"ftp"}]}`,
+					}
+				}))
+				ts.deletePod(getTestPod(nil))
+				assert.Eventually(t, func() bool {
+					return len(ts.getEvents()) == 3
+				}, 2*time.Second, 100*time.Millisecond, "There should be three events.")
+				reasons := make(map[string]string)
+				for _, event := range ts.getEvents() {
+					reasons[event.Reason] = event.InvolvedObject.Name
+				}
+				assert.Equal(t, map[string]string{
+					"ExtensionRegistered":        "test-pod",
+					"ExtensionDeregistered":      "test-pod",
+					"InvalidExtensionAnnotation": "test-pod-invalid",
+				}, reasons)
+			},
+		},
+		{
+			name: "should explain decision for pod",
+			test: func(t *testing.T, ts TestSupport) {
+				ts.addService(getTestService(nil))
+				ts.addPod(getTestPod(func(p *corev1.Pod) {
+					p.ObjectMeta.Annotations = map[string]string{}
+				}))
+				ts.addEndpointSlice(getTestEndpointSlice(nil))
+
+				assert.Nil(t, ts.registrator.Explain("default", "unknown-pod"))
+				decision := ts.registrator.Explain("default", "test-pod")
+				assert.Equal(t, "default/test-pod", decision.Pod)
+				assert.Empty(t, decision.Extensions)
+				assert.Equal(t, []autoregistration.DecisionCheck{
+					{Check: "running and ready", Passed: true},
+					{Check: "namespace enabled", Passed: true, Detail: "default"},
+					{Check: "annotation", Passed: false, Detail: "no enabled extensions"},
+				}, decision.Checks)
+				assert.Len(t, decision.Services, 1)
+				assert.Equal(t, "default/test-service", decision.Services[0].Service)
+				assert.Contains(t, decision.Services[0].Checks, autoregistration.DecisionCheck{Check: "endpoint ready", Passed: true, Detail: "test-service-abcde"})
+				assert.Len(t, decision.Services[0].Extensions, 1)
+				assert.Equal(t, "http://test-service.default.svc.cluster.local:8085", decision.Services[0].Extensions[0].Url)
 			},
 		},
 		{
@@ -240,23 +656,213 @@ func TestAutoRegistration_should_register_extensions(t *testing.T) {
 				assert.Empty(t, added, "Nothing should be registered")
 			},
 		},
+		{
+			name: "should ignore pod matching set-based matchLabelsExclude",
+			args: args{
+				matchLabelsExclude: config.Labels{
+					{
+						Key:      "app",
+						Operator: "In",
+						Values:   []string{"extension-abc", "extension-xyz"},
+					},
+				},
+			},
+			test: func(t *testing.T, ts TestSupport) {
+				ts.addPod(getTestPod(nil))
+				added, _ := ts.getRegistrations()
+				assert.Empty(t, added, "Nothing should be registered")
+			},
+		},
+		{
+			name: "should add pod matching set-based matchLabels",
+			args: args{
+				matchLabels: config.Labels{
+					{
+						Key:      "app",
+						Operator: "Exists",
+					},
+					{
+						Key:      "legacy",
+						Operator: "DoesNotExist",
+					},
+				},
+			},
+			test: func(t *testing.T, ts TestSupport) {
+				ts.addPod(getTestPod(nil))
+				added, _ := ts.getRegistrations()
+				assert.Len(t, added, 1, "There should be one added extension.")
+			},
+		},
+		{
+			name: "should only add pods of the configured namespaces",
+			args: args{
+				namespaces: []string{"team-a", "team-b"},
+			},
+			test: func(t *testing.T, ts TestSupport) {
+				ts.addPod(getTestPod(nil))
+				added, _ := ts.getRegistrations()
+				assert.Empty(t, added, "Nothing should be registered")
+				ts.addPod(getTestPod(func(p *corev1.Pod) {
+					p.Namespace = "team-a"
+				}))
+				added, _ = ts.getRegistrations()
+				assert.Len(t, added, 1, "There should be one added extension.")
+			},
+		},
+		{
+			name: "should ignore pods of excluded namespaces",
+			args: args{
+				namespacesExclude: []string{"default"},
+			},
+			test: func(t *testing.T, ts TestSupport) {
+				ts.addPod(getTestPod(nil))
+				added, _ := ts.getRegistrations()
+				assert.Empty(t, added, "Nothing should be registered")
+				ts.addPod(getTestPod(func(p *corev1.Pod) {
+					p.Namespace = "team-a"
+				}))
+				added, _ = ts.getRegistrations()
+				assert.Len(t, added, 1, "There should be one added extension.")
+			},
+		},
+		{
+			name: "should add and remove namespaces matching the namespace selector",
+			args: args{
+				namespaceSelector: config.Labels{{Key: "steadybit.com/extensions", Value: "true"}},
+			},
+			test: func(t *testing.T, ts TestSupport) {
+				namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a", Labels: map[string]string{"steadybit.com/extensions": "true"}}}
+				ts.addPod(getTestPod(nil))
+				added, _ := ts.getRegistrations()
+				assert.Empty(t, added, "Nothing should be registered")
+
+				ts.addNamespace(namespace)
+				ts.addPod(getTestPod(func(p *corev1.Pod) {
+					p.Namespace = "team-a"
+				}))
+				added, _ = ts.getRegistrations()
+				assert.Len(t, added, 1, "There should be one added extension.")
+
+				namespace.Labels = map[string]string{}
+				ts.updateNamespace(namespace)
+				_, removed := ts.getRegistrations()
+				assert.Len(t, removed, 1, "There should be one removed extension.")
+			},
+		},
+		{
+			name: "should ignore pods and services of opted-out namespaces",
+			test: func(t *testing.T, ts TestSupport) {
+				namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default", Labels: map[string]string{"steadybit.com/extension-auto-registration-disabled": "true"}}}
+				ts.addNamespace(namespace)
+				ts.addPod(getTestPod(nil))
+				ts.addService(getTestService(nil))
+				added, _ := ts.getRegistrations()
+				assert.Empty(t, added, "Nothing should be registered")
+
+				namespace.Labels = map[string]string{}
+				ts.updateNamespace(namespace)
+				added, _ = ts.getRegistrations()
+				assert.Len(t, added, 1, "There should be one added extension.")
+
+				namespace.Annotations = map[string]string{"steadybit.com/extension-auto-registration-disabled": "true"}
+				ts.updateNamespace(namespace)
+				_, removed := ts.getRegistrations()
+				assert.Len(t, removed, 1, "There should be one removed extension.")
+			},
+		},
+		{
+			name: "should merge namespace defaults under pod annotations",
+			test: func(t *testing.T, ts TestSupport) {
+				ts.addNamespace(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default", Annotations: map[string]string{
+					"steadybit.com/extension-auto-registration-defaults": `{"protocol":"https","path":"/ext","types":["host"]}`,
+				}}})
+				ts.addPod(getTestPod(func(p *corev1.Pod) {
+					p.ObjectMeta.Annotations = map[string]string{
+						"steadybit.com/extension-auto-registration": `{"version":2,"extensions":[{"port":8080,"path":"/"}]}`,
+					}
+				}))
+				added, _ := ts.getRegistrations()
+				assert.Len(t, added, 1, "There should be one added extension.")
+				assert.Contains(t, added[0], `"url":"https://192.168.1.1:8080/"`)
+				assert.Contains(t, added[0], `"types":["host"]`)
+			},
+		},
+		{
+			name: "should add daemonset pod on the local node in node-local mode",
+			args: args{
+				nodeName: "node-a",
+			},
+			test: func(t *testing.T, ts TestSupport) {
+				ts.addPod(getTestPod(func(p *corev1.Pod) {
+					p.Spec.NodeName = "node-a"
+				}))
+				added, _ := ts.getRegistrations()
+				assert.Len(t, added, 1, "There should be one added extension.")
+				assert.Equal(t, "{\"url\":\"http://192.168.1.1:8080\",\"restrictedPorts\":{\"8080\":\"ContainerPort (test-container)\",\"8081\":\"LivenessProbe (test-container)\",\"8082\":\"ReadinessProbe (test-container)\"},\"restrictedIps\":[\"192.168.1.1\"]}", added[0])
+			},
+		},
+		{
+			name: "should ignore daemonset pod on other nodes in node-local mode",
+			args: args{
+				nodeName: "node-a",
+			},
+			test: func(t *testing.T, ts TestSupport) {
+				ts.addPod(getTestPod(func(p *corev1.Pod) {
+					p.Spec.NodeName = "node-b"
+				}))
+				added, _ := ts.getRegistrations()
+				assert.Empty(t, added, "Nothing should be registered")
+			},
+		},
+		{
+			name: "should add deployment pod on other nodes for existing service in node-local mode",
+			args: args{
+				nodeName: "node-a",
+			},
+			test: func(t *testing.T, ts TestSupport) {
+				ts.addService(getTestService(nil))
+				ts.addPod(getTestPod(func(p *corev1.Pod) {
+					p.ObjectMeta.Annotations = map[string]string{}
+					p.Spec.NodeName = "node-b"
+				}))
+				ts.addEndpointSlice(getTestEndpointSlice(nil))
+				added, _ := ts.getRegistrations()
+				assert.Len(t, added, 1, "There should be one added extension.")
+				assert.Equal(t, "{\"url\":\"http://test-service.default.svc.cluster.local:8085\",\"restrictedPorts\":{\"8080\":\"ContainerPort (test-container)\",\"8081\":\"LivenessProbe (test-container)\",\"8082\":\"ReadinessProbe (test-container)\",\"8085\":\"ServicePort\"},\"restrictedIps\":[\"555.555.555.555\",\"192.168.1.1\"]}", added[0])
+			},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			agent := createMockAgent()
 			defer agent.Close()
-			httpClient := resty.New()
-			httpClient.BaseURL = agent.URL
+			agentClient, err := autoregistration.NewAgentClient(autoregistration.AgentClientOptions{Url: agent.URL, Timeout: 5 * time.Second})
+			require.NoError(t, err)
 
+			config.Config.NodeName = tt.args.nodeName
+			config.Config.NodeLocalRegistration = tt.args.nodeName != ""
+			config.Config.MatchLabels = tt.args.matchLabels
+			config.Config.MatchLabelsExclude = tt.args.matchLabelsExclude
+			config.Config.Namespaces = tt.args.namespaces
+			config.Config.NamespacesExclude = tt.args.namespacesExclude
+			config.Config.NamespaceSelector = tt.args.namespaceSelector
+			config.Config.DefaultHealthPort = 8081
+			config.Config.PreferredIpFamily = tt.args.preferredIpFamily
+			if config.Config.PreferredIpFamily == "" {
+				config.Config.PreferredIpFamily = config.IpFamilyPrimary
+			}
+			config.Config.RegisterViaHostPort = tt.args.registerViaHostPort
 			stopCh := make(chan struct{})
 			defer close(stopCh)
 			k8sclient, k8stestclient := getTestClient(stopCh)
+			k8sclient.StartEventRecorder(k8stestclient, stopCh)
 
 			config.Config.AgentRegistrationInterval = 1 * time.Second
 			config.Config.AgentRegistrationIntervalAfterError = 1 * time.Second
-			config.Config.MatchLabels = tt.args.matchLabels
-			config.Config.MatchLabelsExclude = tt.args.matchLabelsExclude
-			registrator := autoregistration.UpdateAgentExtensions(httpClient, k8sclient)
+			config.Config.AgentRegistrationReconcileInterval = 2 * time.Second
+			config.Config.AgentDeregistrationGracePeriod = tt.args.gracePeriod
+			registrator := autoregistration.UpdateAgentExtensions(agentClient, k8sclient)
+			defer registrator.Stop(false)
 
 			tt.test(t, TestSupport{
 				addPod: func(pod *corev1.Pod) {
@@ -265,6 +871,10 @@ func TestAutoRegistration_should_register_extensions(t *testing.T) {
 					time.Sleep(100 * time.Millisecond)
 					waitUntilSynched(t, registrator)
 				},
+				addPodWithoutSync: func(pod *corev1.Pod) {
+					_, err := k8stestclient.CoreV1().Pods(pod.Namespace).Create(context.Background(), pod, metav1.CreateOptions{})
+					assert.NoError(t, err, "Pod creation should succeed")
+				},
 				deletePod: func(pod *corev1.Pod) {
 					err := k8stestclient.CoreV1().Pods(pod.Namespace).Delete(context.Background(), pod.Name, metav1.DeleteOptions{})
 					assert.NoError(t, err, "Pod deletion should succeed")
@@ -295,6 +905,41 @@ func TestAutoRegistration_should_register_extensions(t *testing.T) {
 					time.Sleep(100 * time.Millisecond)
 					waitUntilSynched(t, registrator)
 				},
+				addEndpointSlice: func(endpointSlice *discoveryv1.EndpointSlice) {
+					_, err := k8stestclient.DiscoveryV1().EndpointSlices(endpointSlice.Namespace).Create(context.Background(), endpointSlice, metav1.CreateOptions{})
+					assert.NoError(t, err, "Endpoint slice creation should succeed")
+					time.Sleep(100 * time.Millisecond)
+					waitUntilSynched(t, registrator)
+				},
+				updateEndpointSlice: func(endpointSlice *discoveryv1.EndpointSlice) {
+					_, err := k8stestclient.DiscoveryV1().EndpointSlices(endpointSlice.Namespace).Update(context.Background(), endpointSlice, metav1.UpdateOptions{})
+					assert.NoError(t, err, "Endpoint slice update should succeed")
+					time.Sleep(100 * time.Millisecond)
+					waitUntilSynched(t, registrator)
+				},
+				addNamespace: func(namespace *corev1.Namespace) {
+					_, err := k8stestclient.CoreV1().Namespaces().Create(context.Background(), namespace, metav1.CreateOptions{})
+					assert.NoError(t, err, "Namespace creation should succeed")
+					time.Sleep(100 * time.Millisecond)
+					waitUntilSynched(t, registrator)
+				},
+				updateNamespace: func(namespace *corev1.Namespace) {
+					_, err := k8stestclient.CoreV1().Namespaces().Update(context.Background(), namespace, metav1.UpdateOptions{})
+					assert.NoError(t, err, "Namespace update should succeed")
+					time.Sleep(100 * time.Millisecond)
+					waitUntilSynched(t, registrator)
+				},
+				registrator: registrator,
+				getEvents: func() []corev1.Event {
+					events, err := k8stestclient.CoreV1().Events("").List(context.Background(), metav1.ListOptions{})
+					assert.NoError(t, err, "Listing events should succeed")
+					return events.Items
+				},
+				restartAgent: func() {
+					MU.Lock()
+					defer MU.Unlock()
+					CurrentExtensions = []string{}
+				},
 				getRegistrations: func() (added []string, removed []string) {
 					MU.RLock()
 					defer MU.RUnlock()
@@ -307,6 +952,106 @@ func TestAutoRegistration_should_register_extensions(t *testing.T) {
 	}
 }
 
+func TestAutoRegistration_should_keep_existing_registrations_on_start(t *testing.T) {
+	config.Config.NodeName = ""
+	config.Config.NodeLocalRegistration = false
+	config.Config.MatchLabels = nil
+	config.Config.MatchLabelsExclude = nil
+	config.Config.Namespaces = nil
+	config.Config.NamespacesExclude = nil
+	config.Config.NamespaceSelector = nil
+	config.Config.DefaultHealthPort = 8081
+	config.Config.PreferredIpFamily = config.IpFamilyPrimary
+	config.Config.RegisterViaHostPort = false
+	config.Config.AgentRegistrationInterval = 1 * time.Second
+	config.Config.AgentRegistrationIntervalAfterError = 1 * time.Second
+	config.Config.AgentRegistrationReconcileInterval = 2 * time.Second
+	config.Config.AgentDeregistrationGracePeriod = 0
+	config.Config.StateFile = filepath.Join(t.TempDir(), "state.json")
+	defer func() { config.Config.StateFile = "" }()
+	require.NoError(t, os.WriteFile(config.Config.StateFile, []byte(`{"registrations":[{"url":"http://192.168.1.1:8080","restrictedPorts":{"8080":"ContainerPort (test-container)","8081":"LivenessProbe (test-container)","8082":"ReadinessProbe (test-container)"},"restrictedIps":["192.168.1.1"]}]}`), 0o600))
+
+	agent := createMockAgent()
+	defer agent.Close()
+	MU.Lock()
+	CurrentExtensions = []string{"{\"url\":\"http://192.168.1.1:8080\",\"restrictedPorts\":{\"8080\":\"ContainerPort (test-container)\",\"8081\":\"LivenessProbe (test-container)\",\"8082\":\"ReadinessProbe (test-container)\"},\"restrictedIps\":[\"192.168.1.1\"]}"}
+	MU.Unlock()
+	agentClient, err := autoregistration.NewAgentClient(autoregistration.AgentClientOptions{Url: agent.URL, Timeout: 5 * time.Second})
+	require.NoError(t, err)
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	k8sclient, k8stestclient := getTestClient(stopCh)
+	_, err = k8stestclient.CoreV1().Pods("default").Create(context.Background(), getTestPod(nil), metav1.CreateOptions{})
+	require.NoError(t, err, "Pod creation should succeed")
+	assert.Eventually(t, func() bool { return k8sclient.PodByName("default", "test-pod") != nil }, 2*time.Second, 10*time.Millisecond)
+
+	registrator := autoregistration.UpdateAgentExtensions(agentClient, k8sclient)
+	defer registrator.Stop(false)
+	waitUntilSynched(t, registrator)
+
+	MU.RLock()
+	defer MU.RUnlock()
+	assert.Empty(t, RemovedExtensions, "The existing registration should not be removed on start")
+	assert.Empty(t, AddedExtensions, "The existing registration should not be added again on start")
+	assert.Len(t, CurrentExtensions, 1)
+}
+
+func TestAutoRegistration_should_not_record_events_when_explaining(t *testing.T) {
+	config.Config.NodeName = ""
+	config.Config.NodeLocalRegistration = false
+	config.Config.MatchLabels = nil
+	config.Config.MatchLabelsExclude = nil
+	config.Config.Namespaces = nil
+	config.Config.NamespacesExclude = nil
+	config.Config.NamespaceSelector = nil
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	k8sclient, k8stestclient := getTestClient(stopCh)
+	k8sclient.StartEventRecorder(k8stestclient, stopCh)
+	_, err := k8stestclient.CoreV1().Pods("default").Create(context.Background(), getTestPod(func(p *corev1.Pod) {
+		p.ObjectMeta.Annotations = map[string]string{
+			"steadybit.com/extension-auto-registration": `{"extensions":[{"port":8080,"protocol":"ftp"}]}`,
+		}
+	}), metav1.CreateOptions{})
+	require.NoError(t, err, "Pod creation should succeed")
+	assert.Eventually(t, func() bool { return k8sclient.PodByName("default", "test-pod") != nil }, 2*time.Second, 10*time.Millisecond)
+
+	// not started, the pod is only seen by the explanation
+	registrator := autoregistration.NewAutoRegistration(nil, k8sclient)
+	decision := registrator.Explain("default", "test-pod")
+	require.NotNil(t, decision)
+	assert.Contains(t, decision.Checks, autoregistration.DecisionCheck{Check: "annotation valid", Passed: false, Detail: `extensions[0]: protocol must be http or https, got "ftp"`})
+
+	time.Sleep(500 * time.Millisecond)
+	events, err := k8stestclient.CoreV1().Events("").List(context.Background(), metav1.ListOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, events.Items, "Explaining a decision should not record events")
+}
+
+func TestAutoRegistration_should_explain_decision_for_pod_not_matching_matchLabels(t *testing.T) {
+	config.Config.NodeName = ""
+	config.Config.NodeLocalRegistration = false
+	config.Config.MatchLabels = config.Labels{{Key: "app", Value: "extension-abc"}}
+	defer func() { config.Config.MatchLabels = nil }()
+	config.Config.MatchLabelsExclude = nil
+	config.Config.Namespaces = nil
+	config.Config.NamespacesExclude = nil
+	config.Config.NamespaceSelector = nil
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	// the pod exists before the pod informers list the pods matching the label selector
+	clientset := testclient.NewSimpleClientset(getTestPod(nil))
+	k8sclient := client.CreateClient(clientset, stopCh)
+
+	registrator := autoregistration.NewAutoRegistration(nil, k8sclient)
+	decision := registrator.Explain("default", "test-pod")
+	require.NotNil(t, decision, "The pod should be found although it is not watched")
+	assert.Contains(t, decision.Checks, autoregistration.DecisionCheck{Check: "matchLabels", Passed: false, Detail: "app=extension-abc"})
+	assert.Empty(t, decision.Extensions)
+	assert.Nil(t, registrator.Explain("default", "unknown-pod"))
+}
+
 func waitUntilSynched(t *testing.T, registrator *autoregistration.AutoRegistration) {
 	deadline := time.Now().Add(2 * time.Second)
 	for time.Now().Before(deadline) {
@@ -409,8 +1154,54 @@ func getTestService(modifier func(p *corev1.Service)) *corev1.Service {
 	return &newService
 }
 
+func getTestEndpointSlice(modifier func(e *discoveryv1.EndpointSlice)) *discoveryv1.EndpointSlice {
+	newEndpointSlice := discoveryv1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-service-abcde",
+			Namespace: "default",
+			Labels: map[string]string{
+				discoveryv1.LabelServiceName: "test-service",
+			},
+		},
+		AddressType: discoveryv1.AddressTypeIPv4,
+		Endpoints: []discoveryv1.Endpoint{
+			{
+				Addresses: []string{"192.168.1.1"},
+				Conditions: discoveryv1.EndpointConditions{
+					Ready:   new(true),
+					Serving: new(true),
+				},
+				TargetRef: &corev1.ObjectReference{
+					Kind:      "Pod",
+					Namespace: "default",
+					Name:      "test-pod",
+				},
+			},
+		},
+		Ports: []discoveryv1.EndpointPort{
+			{
+				Port: new(int32(8080)),
+			},
+		},
+	}
+	if modifier != nil {
+		modifier(&newEndpointSlice)
+	}
+	return &newEndpointSlice
+}
+
 func getTestClient(stopCh <-chan struct{}) (*client.Client, kubernetes.Interface) {
 	clientset := testclient.NewSimpleClientset()
 	k8sclient := client.CreateClient(clientset, stopCh)
 	return k8sclient, clientset
 }
+
+func countOperations(operations []string, operation string) int {
+	count := 0
+	for _, o := range operations {
+		if o == operation {
+			count++
+		}
+	}
+	return count
+}
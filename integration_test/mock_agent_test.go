@@ -1,6 +1,7 @@
 package autoregistration
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
 	"net"
@@ -16,12 +17,18 @@ var MU = &sync.RWMutex{}
 var AddedExtensions []string
 var RemovedExtensions []string
 var CurrentExtensions []string
+var Operations []string
+
+// RejectedUrls contains the urls whose registration is answered with the given status code
+var RejectedUrls map[string]int
 
 func createMockAgent() *httptest.Server {
 	MU.Lock()
 	AddedExtensions = []string{}
 	RemovedExtensions = []string{}
 	CurrentExtensions = []string{}
+	Operations = []string{}
+	RejectedUrls = map[string]int{}
 	MU.Unlock()
 	listener, err := net.Listen("tcp", "0.0.0.0:0")
 	if err != nil {
@@ -48,10 +55,17 @@ func createMockAgent() *httptest.Server {
 				w.WriteHeader(http.StatusOK)
 				_, _ = w.Write([]byte(response))
 			} else if strings.HasPrefix(r.URL.Path, "/extensions") && r.Method == http.MethodPost {
-				w.WriteHeader(http.StatusOK)
 				body, _ := io.ReadAll(r.Body)
 				MU.Lock()
+				if status, ok := RejectedUrls[urlOf(string(body))]; ok {
+					Operations = append(Operations, "reject "+urlOf(string(body)))
+					MU.Unlock()
+					w.WriteHeader(status)
+					return
+				}
+				w.WriteHeader(http.StatusOK)
 				AddedExtensions = append(AddedExtensions, string(body))
+				Operations = append(Operations, "add "+urlOf(string(body)))
 				CurrentExtensions = append(CurrentExtensions, string(body))
 				MU.Unlock()
 			} else if strings.HasPrefix(r.URL.Path, "/extensions") && r.Method == http.MethodDelete {
@@ -59,6 +73,7 @@ func createMockAgent() *httptest.Server {
 				body, _ := io.ReadAll(r.Body)
 				MU.Lock()
 				RemovedExtensions = append(RemovedExtensions, string(body))
+				Operations = append(Operations, "remove "+urlOf(string(body)))
 				for i, ext := range CurrentExtensions {
 					if ext == string(body) {
 						CurrentExtensions = append(CurrentExtensions[:i], CurrentExtensions[i+1:]...)
@@ -75,3 +90,11 @@ func createMockAgent() *httptest.Server {
 	log.Info().Str("url", server.URL).Msg("Started Mock-Agent")
 	return &server
 }
+
+func urlOf(extension string) string {
+	var registration struct {
+		Url string `json:"url"`
+	}
+	_ = json.Unmarshal([]byte(extension), &registration)
+	return registration.Url
+}
@@ -1,41 +1,52 @@
 package main
 
 import (
-	"strconv"
-	"time"
+	"os"
 
-	"github.com/go-resty/resty/v2"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"github.com/steadybit/extension-auto-registration-kubernetes/autoregistration"
 	"github.com/steadybit/extension-auto-registration-kubernetes/client"
 	"github.com/steadybit/extension-auto-registration-kubernetes/config"
+	"github.com/steadybit/extension-auto-registration-kubernetes/metrics"
 	"github.com/steadybit/extension-kit/extbuild"
+	"github.com/steadybit/extension-kit/exthttp"
 	"github.com/steadybit/extension-kit/extlogging"
 	"github.com/steadybit/extension-kit/extruntime"
+	"github.com/steadybit/extension-kit/extsignals"
 )
 
 func main() {
 	stopCh := make(chan struct{})
-	defer close(stopCh)
 
 	extlogging.InitZeroLog()
 	extbuild.PrintBuildInformation()
 	extruntime.LogRuntimeInformation(zerolog.DebugLevel)
 	config.ParseConfiguration()
+	extsignals.ActivateSignalHandlers()
 	initKlogBridge(config.Config.LogKubernetesHttpRequests)
 
-	httpClientAgent := resty.New()
-	httpClientAgent.BaseURL = "http://localhost:" + strconv.Itoa(config.Config.AgentPort)
-	httpClientAgent.SetDisableWarn(true)
+	agents := createAgents()
 
 	k8sClient := client.PrepareClient(stopCh)
-
-	//Sleep before first discovery to give the agent time to start
-	log.Info().Float64("seconds", config.Config.AgentRegistrationInitialDelay.Seconds()).Msg("Initial delay before starting the discovery.")
-	time.Sleep(config.Config.AgentRegistrationInitialDelay)
-	autoregistration.UpdateAgentExtensions(httpClientAgent, k8sClient)
-
-	// Wait indefinitely
+	registrator := autoregistration.NewAutoRegistrationForAgents(agents, k8sClient)
+	registrator.RegisterStatusHandlers()
+	extsignals.AddSignalHandler(extsignals.SignalHandler{
+		Handler: func(signal os.Signal) {
+			log.Info().Msg("Stopping auto registration")
+			registrator.Stop(config.Config.DeregisterOnShutdown)
+			close(stopCh)
+		},
+		Order: extsignals.OrderStopCustom,
+		Name:  "StopAutoRegistration",
+	})
+	metrics.RegisterHandler()
+	go exthttp.Listen(exthttp.ListenOpts{
+		Port: 8088,
+	})
+
+	registrator.Start()
+
+	// Wait until the signal handlers terminate the process
 	select {}
 }
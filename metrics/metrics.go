@@ -0,0 +1,118 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2026 Steadybit GmbH
+
+package metrics
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	namespace = "steadybit"
+	subsystem = "auto_registration"
+)
+
+var (
+	lastSuccessfulSync atomic.Int64
+	dirtySince         atomic.Int64
+
+	DiscoveredExtensions = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "discovered_extensions",
+		Help:      "Number of discovered extensions per namespace.",
+	}, []string{"namespace"})
+	RegistrationsAdded = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "registrations_added_total",
+		Help:      "Number of extensions registered at the agent.",
+	}, []string{"agent"})
+	RegistrationsRemoved = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "registrations_removed_total",
+		Help:      "Number of extensions deregistered at the agent.",
+	}, []string{"agent"})
+	AgentErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "agent_errors_total",
+		Help:      "Number of failed agent API calls by agent, operation (get, add, delete) and HTTP status (or 'error' if no response was received).",
+	}, []string{"agent", "operation", "status"})
+	RegistrationFailures = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "registration_failures",
+		Help:      "Number of failed registrations and deregistrations by kind: 'transient' failures are retried with backoff, 'permanent' ones (rejected by the agent) are not retried until the registration changes.",
+	}, []string{"kind"})
+	AgentSyncSucceeded = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "agent_sync_succeeded",
+		Help:      "1 if the last sync with the agent succeeded, 0 if it failed or failed registrations are retried.",
+	}, []string{"agent"})
+	SyncDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "sync_duration_seconds",
+		Help:      "Duration of the syncs with the agent.",
+		Buckets:   prometheus.DefBuckets,
+	})
+	InformerEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "informer_events_total",
+		Help:      "Number of Kubernetes informer events by resource and event type.",
+	}, []string{"resource", "event"})
+	_ = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "seconds_since_last_successful_sync",
+		Help:      "Seconds since the last successful sync with the agent (the least recently synced one of multiple agents). -1 if there was no successful sync yet.",
+	}, func() float64 {
+		return secondsSince(lastSuccessfulSync.Load(), -1)
+	})
+	_ = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "dirty_seconds",
+		Help:      "Seconds since discovered changes are waiting to be synced to the agent. 0 if there are no pending changes.",
+	}, func() float64 {
+		return secondsSince(dirtySince.Load(), 0)
+	})
+)
+
+func secondsSince(unixNano int64, fallback float64) float64 {
+	if unixNano == 0 {
+		return fallback
+	}
+	return time.Since(time.Unix(0, unixNano)).Seconds()
+}
+
+// SetLastSuccessfulSync records the time of the last successful sync with the agent. With multiple agents, it is the
+// oldest of the last successful syncs.
+func SetLastSuccessfulSync(t time.Time) {
+	lastSuccessfulSync.Store(t.UnixNano())
+}
+
+// SetDirty records whether there are discovered changes not yet synced to the agent. The age of the changes is kept
+// until SetDirty(false) is called.
+func SetDirty(dirty bool) {
+	if dirty {
+		dirtySince.CompareAndSwap(0, time.Now().UnixNano())
+	} else {
+		dirtySince.Store(0)
+	}
+}
+
+// RegisterHandler serves the metrics on /metrics.
+func RegisterHandler() {
+	http.Handle("/metrics", promhttp.Handler())
+}
@@ -0,0 +1,32 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2026 Steadybit GmbH
+
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetDirty_should_keep_age_of_oldest_change(t *testing.T) {
+	SetDirty(false)
+	assert.Zero(t, secondsSince(dirtySince.Load(), 0))
+
+	SetDirty(true)
+	since := dirtySince.Load()
+	assert.NotZero(t, since)
+
+	time.Sleep(10 * time.Millisecond)
+	SetDirty(true)
+	assert.Equal(t, since, dirtySince.Load(), "A later change must not reset the age.")
+
+	SetDirty(false)
+	assert.Zero(t, dirtySince.Load())
+}
+
+func TestSecondsSince(t *testing.T) {
+	assert.Equal(t, float64(-1), secondsSince(0, -1))
+	assert.InDelta(t, 60, secondsSince(time.Now().Add(-time.Minute).UnixNano(), -1), 1)
+}